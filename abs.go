@@ -7,6 +7,25 @@ type RPCTransport interface {
 	Close() error
 }
 
+// CodecNegotiator is implemented by transports which agree on body codec
+// while establishing connection
+type CodecNegotiator interface {
+	// CodecName returns negotiated codec name or empty string
+	CodecName() string
+}
+
+// Authenticated is implemented by transports which authenticate peer
+// while establishing connection
+type Authenticated interface {
+	// Identity returns identity of authenticated peer
+	Identity() interface{}
+}
+
+// ConnInfoProvider is implemented by transports which know details of connection
+type ConnInfoProvider interface {
+	ConnInfo() ConnInfo
+}
+
 // SessionProtocol represent abstract RPC protocol
 type SessionProtocol interface {
 	OnConnect(*RPCConn)
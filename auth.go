@@ -0,0 +1,66 @@
+package wsrpc
+
+import (
+	"context"
+	"net/http"
+)
+
+// Authenticator inspects HTTP request of websocket handshake before upgrade
+// (headers, cookies, query tokens) and returns identity of client, e.g. user
+// or token claims. Identity is available with RPCConn.Identity. Returned
+// error rejects connection with HTTP status of *AuthError or with
+// 401 Unauthorized for other errors.
+type Authenticator func(r *http.Request) (identity interface{}, err error)
+
+// AuthError rejects websocket handshake with given HTTP status
+type AuthError struct {
+	Status  int
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return e.Message
+}
+
+// WithAuthenticator sets authenticator of websocket handshake
+func WithAuthenticator(auth Authenticator) Option {
+	return func(o *options) {
+		o.authenticator = auth
+	}
+}
+
+// WithHandshakeHeader sets HTTP header sent by client in websocket handshake,
+// e.g. authorization token or cookies
+func WithHandshakeHeader(header http.Header) Option {
+	return func(o *options) {
+		o.handshakeHeader = header
+	}
+}
+
+// authenticate returns identity of client or writes rejection response
+func (h *WsHandler) authenticate(w http.ResponseWriter, r *http.Request) (interface{}, bool) {
+	if h.auth == nil {
+		return nil, true
+	}
+	identity, err := h.auth(r)
+	if err == nil {
+		return identity, true
+	}
+
+	status := http.StatusUnauthorized
+	if ae, ok := err.(*AuthError); ok && ae.Status != 0 {
+		status = ae.Status
+	}
+	h.log.Warningf("websocket handshake from %s rejected: %s", r.RemoteAddr, err.Error())
+	http.Error(w, err.Error(), status)
+	return nil, false
+}
+
+type connCtxKey struct{}
+
+// ConnFromContext returns session of server method call context,
+// nil is returned for other contexts
+func ConnFromContext(ctx context.Context) *RPCConn {
+	conn, _ := ctx.Value(connCtxKey{}).(*RPCConn)
+	return conn
+}
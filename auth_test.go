@@ -0,0 +1,66 @@
+package wsrpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHandshakeAuthentication(t *testing.T) {
+	auth := func(r *http.Request) (interface{}, error) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		switch token {
+		case "":
+			return nil, fmt.Errorf("no token")
+		case "alice-token":
+			return "alice", nil
+		case "bob-token":
+			return "bob", nil
+		}
+		return nil, &AuthError{http.StatusForbidden, "invalid token"}
+	}
+	closech := make(chan struct{})
+	go ServeWSRPC(func() SessionProtocol { return &MyProtocol{} }, ":8093", "/test/wsrpc", &DummyLogger{}, closech, WithAuthenticator(auth))
+	time.Sleep(100 * time.Millisecond)
+	defer close(closech)
+
+	// rejected handshakes
+	for url, status := range map[string]int{
+		"ws://127.0.0.1:8093/test/wsrpc":              http.StatusUnauthorized,
+		"ws://127.0.0.1:8093/test/wsrpc?token=forged": http.StatusForbidden,
+	} {
+		_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+		if err == nil || resp == nil || resp.StatusCode != status {
+			t.Fatalf("%s: status %d expected, got %v, %v", url, status, resp, err)
+		}
+	}
+
+	// identity of header and query tokens
+	header := http.Header{"Authorization": []string{"Bearer alice-token"}}
+	for identity, opts := range map[string][]Option{
+		"alice": {WithHandshakeHeader(header)},
+		"bob":   nil,
+	} {
+		addr := "ws://127.0.0.1:8093/test/wsrpc"
+		if opts == nil {
+			addr += "?token=bob-token"
+		}
+		cli, err := ClientWSRPC(&MyProtocol{}, addr, time.Second, nil, &DummyLogger{}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := Invoke[SomeReq, SomeReq](context.Background(), cli, "MyIdentity", &SomeReq{})
+		cli.Close()
+		if err != nil || resp.Name != identity {
+			t.Fatalf("identity %s expected, got %v, %v", identity, resp, err)
+		}
+	}
+}
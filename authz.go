@@ -0,0 +1,99 @@
+package wsrpc
+
+import (
+	"fmt"
+)
+
+// Authorizer decides whether session may call protocol method or send
+// client notification, returned error rejects call with ErrCodePermissionDenied
+// and drops notification
+type Authorizer func(conn *RPCConn, method string) error
+
+// TopicAuthorizer decides whether session may subscribe to topic,
+// returned error rejects subscription with ErrCodePermissionDenied
+type TopicAuthorizer func(conn *RPCConn, topic string) error
+
+// MethodRoles maps protocol methods and client notifications to roles allowed
+// to call them. Methods which are not in map may be called by any session,
+// roles under "*" key are required for them if it is set.
+type MethodRoles map[string][]string
+
+// anyMethod is MethodRoles key of methods without declared roles
+const anyMethod = "*"
+
+// RoleHolder is implemented by identities of authenticated clients
+// which have roles or scopes
+type RoleHolder interface {
+	HasRole(role string) bool
+}
+
+// WithMethodRoles restricts protocol methods to sessions which identity
+// has at least one of declared roles
+func WithMethodRoles(roles MethodRoles) Option {
+	return func(o *options) {
+		o.methodRoles = roles
+	}
+}
+
+// WithAuthorizer sets policy function checking every call of protocol method,
+// it is called after roles declared with WithMethodRoles are checked
+func WithAuthorizer(a Authorizer) Option {
+	return func(o *options) {
+		o.authorizer = a
+	}
+}
+
+// WithTopicAuthorizer sets policy function checking every subscription to topic,
+// roles and authorizer of protocol methods don't apply to subscriptions
+func WithTopicAuthorizer(a TopicAuthorizer) Option {
+	return func(o *options) {
+		o.topicAuthorizer = a
+	}
+}
+
+// checkMethodRoles returns error if roles are declared for unknown methods
+func checkMethodRoles(roles MethodRoles, pd *protocolDetails) error {
+	for m := range roles {
+		if _, ok := pd.methods[m]; ok || m == anyMethod {
+			continue
+		}
+		if _, ok := pd.clientNotifications[m]; !ok {
+			return fmt.Errorf("roles of unknown method %s", m)
+		}
+	}
+	return nil
+}
+
+// newAuthorizer combines declared roles and policy function into one
+// authorizer, nil is returned if calls aren't restricted
+func newAuthorizer(roles MethodRoles, policy Authorizer) Authorizer {
+	if len(roles) == 0 && policy == nil {
+		return nil
+	}
+	return func(conn *RPCConn, method string) error {
+		allowed, ok := roles[method]
+		if !ok {
+			allowed, ok = roles[anyMethod]
+		}
+		if ok && !hasAnyRole(conn.Identity(), allowed) {
+			return fmt.Errorf("method %s is not allowed", method)
+		}
+		if policy != nil {
+			return policy(conn, method)
+		}
+		return nil
+	}
+}
+
+func hasAnyRole(identity interface{}, roles []string) bool {
+	rh, ok := identity.(RoleHolder)
+	if !ok {
+		return false
+	}
+	for _, r := range roles {
+		if rh.HasRole(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package wsrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type testRoles []string
+
+func (r testRoles) HasRole(role string) bool {
+	for _, rr := range r {
+		if rr == role {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMethodAuthorization(t *testing.T) {
+	pd, _ := parseSessionProtocol(&MyProtocol{})
+	if err := checkMethodRoles(MethodRoles{"MyMethod": {"admin"}, "*": {"user"}}, pd); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkMethodRoles(MethodRoles{"NoMethod": {"admin"}}, pd); err == nil {
+		t.Fatal("error expected for roles of unknown method")
+	}
+
+	readOnly := func(conn *RPCConn, method string) error {
+		if method == "MyAppend" && conn.Identity() == "guest" {
+			return fmt.Errorf("guest can't append")
+		}
+		return nil
+	}
+	for _, tc := range []struct {
+		opts     []Option
+		identity interface{}
+		method   string
+		allowed  bool
+	}{
+		{nil, nil, "MyMethod", true},
+		{[]Option{WithMethodRoles(MethodRoles{"MyMethod": {"admin"}})}, nil, "MyMethod", false},
+		{[]Option{WithMethodRoles(MethodRoles{"MyMethod": {"admin"}})}, "admin", "MyMethod", false},
+		{[]Option{WithMethodRoles(MethodRoles{"MyMethod": {"admin"}})}, testRoles{"user"}, "MyMethod", false},
+		{[]Option{WithMethodRoles(MethodRoles{"MyMethod": {"admin", "ops"}})}, testRoles{"user", "ops"}, "MyMethod", true},
+		{[]Option{WithMethodRoles(MethodRoles{"MyMethod": {"admin"}})}, nil, "MyFind", true},
+		{[]Option{WithMethodRoles(MethodRoles{"MyMethod": {"admin"}, "*": {"user"}})}, testRoles{"user"}, "MyFind", true},
+		{[]Option{WithMethodRoles(MethodRoles{"MyMethod": {"admin"}, "*": {"user"}})}, testRoles{"user"}, "MyMethod", false},
+		{[]Option{WithMethodRoles(MethodRoles{"*": {"user"}})}, nil, "MyFind", false},
+		{[]Option{WithAuthorizer(readOnly)}, "guest", "MyAppend", false},
+		{[]Option{WithAuthorizer(readOnly)}, "guest", "MyFind", true},
+		{[]Option{WithAuthorizer(readOnly), WithMethodRoles(MethodRoles{"MyFind": {"user"}})}, "guest", "MyFind", false},
+		{[]Option{WithAuthorizer(readOnly), WithMethodRoles(MethodRoles{"MyAppend": {"user"}})}, testRoles{"user"}, "MyAppend", true},
+	} {
+		wp := newWorkersPool(newOptions(tc.opts), pd, &DummyLogger{})
+		conn := newTestConn()
+		conn.identity = tc.identity
+		// body isn't decoded for denied calls
+		resp := wp.callMethod(context.Background(), &MyProtocol{}, conn, NewPacket(PT_REQUEST, tc.method, []byte("invalid")))
+		wp.Close()
+		conn.cancel()
+
+		if resp.Header.Type != PT_ERROR {
+			t.Fatalf("%s by %v: error expected, got %s", tc.method, tc.identity, resp)
+		}
+		err := parseRemoteError(pd, JSONCodec, resp.Body)
+		if errors.Is(err, ErrPermissionDenied) == tc.allowed {
+			t.Fatalf("%s by %v: allowed=%v expected, got %v", tc.method, tc.identity, tc.allowed, err)
+		}
+		if tc.allowed && !errors.Is(err, ErrInvalidRequest) {
+			t.Fatalf("%s by %v: invalid request expected, got %v", tc.method, tc.identity, err)
+		}
+	}
+
+	// unknown methods aren't hidden by authorization
+	wp := newWorkersPool(newOptions([]Option{WithMethodRoles(MethodRoles{"*": {"user"}})}), pd, &DummyLogger{})
+	defer wp.Close()
+	conn := newTestConn()
+	defer conn.cancel()
+	resp := wp.callMethod(context.Background(), &MyProtocol{}, conn, NewPacket(PT_REQUEST, "NoMethod", []byte("{}")))
+	if err := parseRemoteError(pd, JSONCodec, resp.Body); !errors.Is(err, ErrMethodNotFound) {
+		t.Fatalf("method not found expected, got %v", err)
+	}
+}
+
+func TestNotificationAuthorization(t *testing.T) {
+	pd, _ := parseSessionProtocol(&MyProtocol{})
+	if err := checkMethodRoles(MethodRoles{"TypingNotif": {"user"}}, pd); err != nil {
+		t.Fatal(err)
+	}
+
+	muted := func(conn *RPCConn, method string) error {
+		if method == "TypingNotif" && conn.Identity() == "muted" {
+			return fmt.Errorf("user is muted")
+		}
+		return nil
+	}
+	for _, tc := range []struct {
+		opts      []Option
+		identity  interface{}
+		delivered bool
+	}{
+		{nil, nil, true},
+		{[]Option{WithMethodRoles(MethodRoles{"TypingNotif": {"user"}})}, nil, false},
+		{[]Option{WithMethodRoles(MethodRoles{"TypingNotif": {"user"}})}, testRoles{"user"}, true},
+		{[]Option{WithMethodRoles(MethodRoles{"MyMethod": {"admin"}, "*": {"user"}})}, testRoles{"guest"}, false},
+		{[]Option{WithMethodRoles(MethodRoles{"MyMethod": {"admin"}, "*": {"user"}})}, testRoles{"user"}, true},
+		{[]Option{WithAuthorizer(muted)}, "muted", false},
+		{[]Option{WithAuthorizer(muted)}, "talker", true},
+	} {
+		typing := make(chan string, 1)
+		wp := newWorkersPool(newOptions(tc.opts), pd, &DummyLogger{})
+		conn := newTestConn()
+		conn.identity = tc.identity
+		wp.callNotification(&MyProtocol{typing: typing}, conn, NewPacket(PT_NOTIFICATION, "TypingNotif", []byte(`{"user":"Bob"}`)))
+		wp.Close()
+		conn.cancel()
+
+		if delivered := len(typing) == 1; delivered != tc.delivered {
+			t.Fatalf("notification by %v: delivered=%v expected", tc.identity, tc.delivered)
+		}
+	}
+}
+
+// identityConn is fake transport of authenticated client
+type identityConn struct {
+	*FakeConn
+	identity interface{}
+}
+
+func (c identityConn) Identity() interface{} { return c.identity }
+
+func TestTopicAuthorization(t *testing.T) {
+	topicAuth := func(conn *RPCConn, topic string) error {
+		if topic == "admin" && !hasAnyRole(conn.Identity(), []string{"admin"}) {
+			return fmt.Errorf("topic %s is not allowed", topic)
+		}
+		return nil
+	}
+	conns := make(chan RPCTransport)
+	srv, err := NewRPCServer(conns, func() SessionProtocol { return &MyProtocol{} }, &DummyLogger{},
+		WithTopicAuthorizer(topicAuth), WithMethodRoles(MethodRoles{"*": {"admin"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+
+	admin, user := NewFakeConn(), NewFakeConn()
+	conns <- identityConn{admin, testRoles{"admin"}}
+	<-admin.out // hello notification
+	conns <- identityConn{user, testRoles{"user"}}
+	<-user.out
+
+	for _, tc := range []struct {
+		conn    *FakeConn
+		method  string
+		topic   string
+		allowed bool
+	}{
+		{admin, SubscribeMethod, "admin", true},
+		{admin, SubscribeMethod, "news", true},
+		{user, SubscribeMethod, "news", true},
+		{user, SubscribeMethod, "admin", false},
+		{user, UnsubscribeMethod, "admin", true},
+		{user, UnsubscribeMethod, "news", true},
+	} {
+		req := NewPacket(PT_REQUEST, tc.method, []byte(tc.topic))
+		tc.conn.in <- req
+		p := <-tc.conn.out
+		if tc.allowed && p.Header.Type != PT_RESPONSE {
+			t.Fatalf("%s %s: response expected, got %s", tc.method, tc.topic, p)
+		}
+		if !tc.allowed {
+			err := parseRemoteError(srv.protDetails, JSONCodec, p.Body)
+			if p.Header.Type != PT_ERROR || !errors.Is(err, ErrPermissionDenied) {
+				t.Fatalf("%s %s: permission denied expected, got %s", tc.method, tc.topic, p)
+			}
+		}
+	}
+	if n := srv.Subscribers("admin"); n != 1 {
+		t.Fatalf("1 subscriber expected, got %d", n)
+	}
+}
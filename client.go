@@ -1,24 +1,71 @@
 package wsrpc
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
 	"reflect"
+	"runtime/debug"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 type OnNotificationFunc func(interface{}, error)
 
+// ConnectionClosedError is returned by calls of closed client,
+// Err is transport error which has caused the close
+type ConnectionClosedError struct {
+	Err error
+}
+
+func (e *ConnectionClosedError) Error() string {
+	return fmt.Sprintf("connection closed: %s", e.Err)
+}
+
+func (e *ConnectionClosedError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ClosedConnError) true for any connection closed error
+func (e *ConnectionClosedError) Is(target error) bool {
+	return target == ClosedConnError
+}
+
 type RPCClient struct {
-	conn          RPCTransport
+	connLock  sync.Mutex
+	conn      RPCTransport
+	connected bool
+	closing   bool
+	closeCh   chan struct{}
+
+	codec         Codec
+	codecs        []Codec
 	flow          *FlowController
 	notifications chan *Packet
 	closedFlag    int32
+	closeErr      error
+
+	reconnect  *ReconnectPolicy
+	pending    map[string]pendingRequest // requests to resend after reconnect
+	pendingSeq uint64
 
+	prot        SessionProtocol
 	protDetails *protocolDetails
 	onNotifFunc OnNotificationFunc
+	interceptor ClientInterceptor
+
+	handlersLock sync.RWMutex
+	handlers     map[string][]func(interface{}) // typed notification handlers
+
+	callsLock sync.Mutex
+	calls     map[string]context.CancelFunc // running requests of server
+
+	streamsLock  sync.Mutex
+	streams      map[string]*ClientStream
+	streamWindow int
+
+	topicsLock sync.RWMutex
+	topics     map[string]func(interface{}) // handlers of subscribed topics
 
 	log Logger
 }
@@ -29,21 +76,43 @@ func NewRPCClient(
 	timeout time.Duration,
 	onNotifFunc OnNotificationFunc,
 	log Logger,
+	opts ...Option,
 ) (*RPCClient, error) {
 	if conn == nil {
 		return nil, fmt.Errorf("Nil RPCTransport passed")
 	}
+	o := newOptions(opts)
+	codec, err := selectCodec(o.codecs, conn)
+	if err != nil {
+		return nil, err
+	}
+	if o.reconnect != nil && o.reconnect.Dial == nil {
+		return nil, fmt.Errorf("reconnect policy without Dial function")
+	}
 	cli := &RPCClient{
 		conn:          conn,
+		connected:     true,
+		closeCh:       make(chan struct{}),
+		codec:         codec,
+		codecs:        o.codecs,
+		reconnect:     o.reconnect,
+		pending:       make(map[string]pendingRequest),
 		flow:          NewFlowController(timeout),
 		notifications: make(chan *Packet, 100),
 		onNotifFunc:   onNotifFunc,
+		handlers:      make(map[string][]func(interface{})),
+		calls:         make(map[string]context.CancelFunc),
+		streams:       make(map[string]*ClientStream),
+		streamWindow:  o.streamWindow,
+		topics:        make(map[string]func(interface{})),
+		interceptor:   chainClientInterceptors(o.clientInterceptors),
 		log:           log,
 	}
 	pdetails, err := parseSessionProtocol(p)
 	if err != nil {
 		return nil, err
 	}
+	cli.prot = p
 	cli.protDetails = pdetails
 	go cli.loop()
 	go cli.notifLoop()
@@ -51,10 +120,29 @@ func NewRPCClient(
 }
 
 func (cli *RPCClient) Call(method string, request interface{}) (interface{}, error) {
+	return cli.CallContext(context.Background(), method, request)
+}
+
+// CallContext calls remote method and waits for response until ctx is done.
+// Context deadline overrides default client timeout.
+func (cli *RPCClient) CallContext(ctx context.Context, method string, request interface{}) (interface{}, error) {
+	if cli.interceptor != nil {
+		return cli.interceptor(ctx, method, request, cli.invoke)
+	}
+	return cli.invoke(ctx, method, request)
+}
+
+func (cli *RPCClient) invoke(ctx context.Context, method string, request interface{}) (interface{}, error) {
+	if cli.Closed() {
+		return nil, cli.closeErr
+	}
 	md, ok := cli.protDetails.methods[method]
 	if !ok {
 		return nil, fmt.Errorf("unknown method %s", method)
 	}
+	if md.isStream() {
+		return nil, fmt.Errorf("method %s is streaming, use Stream", method)
+	}
 
 	// check request type
 	if md.inType != reflect.TypeOf(request).Elem() {
@@ -62,62 +150,220 @@ func (cli *RPCClient) Call(method string, request interface{}) (interface{}, err
 	}
 
 	// create request message
-	reqBody, err := json.Marshal(request)
+	reqBody, err := cli.codec.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	respPacket, err := cli.roundTrip(ctx, NewPacket(PT_REQUEST, method, reqBody))
 	if err != nil {
 		return nil, err
 	}
-	reqPacket := NewPacket(PT_REQUEST, method, reqBody)
+
+	// unmarshal result
+	outV := reflect.New(md.outType)
+	err = cli.codec.Unmarshal(respPacket.Body, outV.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return outV.Interface(), nil
+}
+
+// roundTrip sends request packet and waits for its response packet
+func (cli *RPCClient) roundTrip(ctx context.Context, reqPacket *Packet) (*Packet, error) {
+	if cli.Closed() {
+		return nil, cli.closeErr
+	}
 
 	// set new response waiter
 	rid := reqPacket.Id()
-	rw := cli.flow.NewWaiter(rid)
+	var rw *Waiter
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		rw = cli.flow.NewWaiterWithDeadline(rid, deadline)
+	} else {
+		rw = cli.flow.NewWaiter(rid)
+	}
+	if cli.Closed() {
+		// client is closed after waiter is set, nobody will fail it
+		cli.flow.GetWaiter(rid)
+		return nil, cli.closeErr
+	}
 
 	// send request to server
-	err = cli.conn.Send(reqPacket)
+	err := cli.sendRequest(reqPacket)
 	if err != nil {
 		cli.flow.GetWaiter(rid)
 		return nil, err
 	}
 
-	respPacket, err := rw.Wait()
+	respPacket, err := rw.WaitContext(ctx)
+	cli.forgetRequest(rid)
 	if err != nil {
+		if err == TimeoutError && hasDeadline {
+			// waiter timeouts at context deadline
+			err = context.DeadlineExceeded
+		}
+		if ctx.Err() != nil || err == TimeoutError {
+			// forget waiter of abandoned call and ask server to stop its processing
+			cli.flow.GetWaiter(rid)
+			if err := cli.send(reqPacket.Cancel(err)); err != nil {
+				cli.log.Debugf("can't send cancel packet: %s", err.Error())
+			}
+		}
 		return nil, err
 	}
+	return respPacket, nil
+}
 
-	// unmarshal result
-	outV := reflect.New(md.outType)
-	err = json.Unmarshal(respPacket.Body, outV.Interface())
+// Notify sends notification to server, notification must be declared
+// in ClientNotifications of session protocol
+func (cli *RPCClient) Notify(notification interface{}) error {
+	nt := reflect.TypeOf(notification)
+	if nt == nil || nt.Kind() != reflect.Ptr {
+		return fmt.Errorf("Notification %s is not declared in protocol", nt)
+	}
+	nd, ok := cli.protDetails.clientNotifications[nt.Elem().Name()]
+	if !ok || nd.nType != nt.Elem() {
+		return fmt.Errorf("Notification %s is not declared in protocol", nt)
+	}
+	if cli.Closed() {
+		return cli.closeErr
+	}
+
+	buf, err := cli.codec.Marshal(notification)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return outV.Interface(), nil
+	return cli.send(NewPacket(PT_NOTIFICATION, nd.nType.Name(), buf))
+}
+
+// send sends packet if client is connected
+func (cli *RPCClient) send(p *Packet) error {
+	cli.connLock.Lock()
+	conn, connected := cli.conn, cli.connected
+	cli.connLock.Unlock()
+	if !connected && cli.reconnect != nil {
+		return DisconnectedError
+	}
+	return conn.Send(p)
+}
+
+// sendRequest sends request packet, requests are queued for resending
+// after reconnect if reconnect policy requires it
+func (cli *RPCClient) sendRequest(p *Packet) error {
+	if cli.reconnect == nil || !cli.reconnect.QueueCalls {
+		return cli.send(p)
+	}
+
+	cli.connLock.Lock()
+	cli.pendingSeq++
+	conn, connected := cli.conn, cli.connected
+	// request may reach server once write is attempted
+	cli.pending[p.Id()] = pendingRequest{seq: cli.pendingSeq, packet: p, written: connected}
+	cli.connLock.Unlock()
+	if !connected {
+		// will be sent after reconnect
+		return nil
+	}
+	if err := conn.Send(p); err != nil {
+		cli.log.Debugf("request %s will be resent after reconnect: %s", p.Id(), err.Error())
+		cli.unwritten(p.Id(), conn)
+	}
+	return nil
+}
+
+// unwritten marks pending request which isn't written to conn,
+// so it is resent after reconnect
+func (cli *RPCClient) unwritten(mid string, conn RPCTransport) {
+	cli.connLock.Lock()
+	defer cli.connLock.Unlock()
+	if pr, ok := cli.pending[mid]; ok && cli.conn == conn {
+		pr.written = false
+		cli.pending[mid] = pr
+	}
+}
+
+func (cli *RPCClient) forgetRequest(mid string) {
+	if cli.reconnect == nil || !cli.reconnect.QueueCalls {
+		return
+	}
+	cli.connLock.Lock()
+	delete(cli.pending, mid)
+	cli.connLock.Unlock()
 }
 
 func (cli *RPCClient) Closed() bool {
 	return atomic.LoadInt32(&cli.closedFlag) == 1
 }
 func (cli *RPCClient) Close() error {
-	return cli.conn.Close()
+	cli.connLock.Lock()
+	if !cli.closing {
+		cli.closing = true
+		close(cli.closeCh)
+	}
+	conn := cli.conn
+	cli.connLock.Unlock()
+	return conn.Close()
 }
 
 func (cli *RPCClient) notifLoop() {
+	ctx := context.WithValue(context.Background(), notificationCtxKey{}, true)
 	for packet := range cli.notifications {
-		if cli.onNotifFunc == nil {
+		if packet.Header.Type == PT_PUBLISH {
+			cli.onPublished(packet)
+			continue
+		}
+		handlers := cli.notifHandlers(packet.Header.Method)
+		if cli.onNotifFunc == nil && len(handlers) == 0 {
 			// just ignore notification
 			continue
 		}
 		vt, ok := cli.protDetails.notifications[packet.Header.Method]
 		if !ok {
-			cli.onNotifFunc(nil, fmt.Errorf("unexpected notification %s", packet.Header.Method))
-			return
+			if cli.onNotifFunc != nil {
+				cli.onNotifFunc(nil, fmt.Errorf("unexpected notification %s", packet.Header.Method))
+			}
+			continue
 		}
 		val := reflect.New(vt)
-		err := json.Unmarshal(packet.Body, val.Interface())
+		err := cli.codec.Unmarshal(packet.Body, val.Interface())
 
-		cli.onNotifFunc(val.Interface(), err)
+		deliver := func(ctx context.Context, name string, n interface{}) (interface{}, error) {
+			if cli.onNotifFunc != nil {
+				cli.onNotifFunc(n, err)
+			}
+			if err == nil && n != nil {
+				for _, h := range handlers {
+					h(n)
+				}
+			} else if err != nil {
+				cli.log.Warningf("can't decode notification %s: %s", name, err.Error())
+			}
+			return nil, err
+		}
+
+		if cli.interceptor == nil {
+			deliver(ctx, packet.Header.Method, val.Interface())
+			continue
+		}
+
+		var notif interface{}
+		if err == nil {
+			notif = val.Interface()
+		}
+		if _, ierr := cli.interceptor(ctx, packet.Header.Method, notif, deliver); ierr != nil && ierr != err {
+			cli.log.Warningf("notification %s rejected by interceptor: %s", packet.Header.Method, ierr)
+		}
 	}
 }
 
+// notifHandlers returns typed handlers of notification
+func (cli *RPCClient) notifHandlers(name string) []func(interface{}) {
+	cli.handlersLock.RLock()
+	defer cli.handlersLock.RUnlock()
+	return cli.handlers[name]
+}
+
 func (cli *RPCClient) onNotif(packet *Packet) {
 	select {
 	case cli.notifications <- packet:
@@ -130,34 +376,152 @@ func (cli *RPCClient) onNotif(packet *Packet) {
 func (cli *RPCClient) loop() {
 	cli.log.Debug("rpc client read loop started")
 	for {
-		packet, err := cli.conn.Recv()
+		cli.connLock.Lock()
+		conn := cli.conn
+		cli.connLock.Unlock()
+
+		err := cli.readLoop(conn)
+		cli.log.Debugf("[cli.loop] closed with error: %s", err.Error())
+
+		cli.connLock.Lock()
+		cli.connected = false
+		closing := cli.closing
+		cli.connLock.Unlock()
+
+		if cli.reconnect != nil && !closing && cli.redial(err) {
+			continue
+		}
+
+		// mark client closed before failing waiters,
+		// so calls started concurrently don't wait for timeout
+		cli.closeErr = &ConnectionClosedError{err}
+		atomic.StoreInt32(&cli.closedFlag, 1)
+		cli.flow.FailAll(cli.closeErr)
+		cli.flow.Close()
+		cli.cancelCalls()
+		cli.failStreams(cli.closeErr)
+		close(cli.notifications)
+		return
+	}
+}
 
+func (cli *RPCClient) readLoop(conn RPCTransport) error {
+	for {
+		packet, err := conn.Recv()
 		if err != nil {
-			cli.log.Debugf("[cli.loop] closed with error: %s", err.Error())
-			close(cli.notifications)
-			atomic.StoreInt32(&cli.closedFlag, 1)
-			return
+			return err
 		}
 
 		switch packet.Header.Type {
+		case PT_STREAM_ITEM:
+			if cs := cli.stream(packet.Id()); cs != nil {
+				cs.push(packet)
+			}
+
+		case PT_STREAM_END:
+			if cs := cli.stream(packet.Id()); cs != nil {
+				cs.finish(nil, nil)
+			}
+
+		case PT_STREAM_ACK:
+			if cs := cli.stream(packet.Id()); cs != nil {
+				cs.credits.add(parseCredits(packet))
+			}
+
 		case PT_ERROR:
+			if cs := cli.stream(packet.Id()); cs != nil {
+				cs.finish(nil, parseRemoteError(cli.protDetails, cli.codec, packet.Body))
+				continue
+			}
 			rw := cli.flow.GetWaiter(packet.Id())
 			if rw != nil {
-				rw.setError(errors.New(string(packet.Body)))
+				rw.setError(parseRemoteError(cli.protDetails, cli.codec, packet.Body))
 			}
 
 		case PT_RESPONSE:
+			if cs := cli.stream(packet.Id()); cs != nil {
+				// response of client streaming method
+				cs.finish(packet, nil)
+				continue
+			}
 			rw := cli.flow.GetWaiter(packet.Id())
 			if rw != nil {
 				rw.setData(packet)
 			}
 
-		case PT_NOTIFICATION:
+		case PT_NOTIFICATION, PT_PUBLISH:
 			cli.onNotif(packet)
 
+		case PT_REQUEST:
+			go cli.serve(cli.startCall(packet.Id()), packet)
+
+		case PT_CANCEL:
+			if cancel := cli.finishCall(packet.Id()); cancel != nil {
+				cancel()
+				cli.log.Debugf("request %s cancelled by server: %s", packet.Id(), string(packet.Body))
+			}
+
 		default:
-			cli.log.Errorf("[cli.loop] unexpected packet type <%s>", packet.Header.Type)
+			cli.log.Errorf("[cli.loop] unexpected packet type <%s>", printableType(packet.Header.Type))
 		}
 	}
+}
+
+// serve processes request of server by client session protocol
+func (cli *RPCClient) serve(ctx context.Context, packet *Packet) {
+	var resp *Packet
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				cli.log.Errorf("panic in method %s: %v\n%s", packet.Header.Method, r, debug.Stack())
+				resp = packet.Error(ErrInternal)
+			}
+		}()
+		resp = dispatchRequest(ctx, cli.protDetails, cli.codec, packet, func(ctx context.Context, m methodDetails, req interface{}) (interface{}, error) {
+			if m.isStream() {
+				return nil, fmt.Errorf("streaming method %s can't be called by server", packet.Header.Method)
+			}
+			return m.call(ctx, cli.prot, req)
+		})
+	}()
+
+	cancel := cli.finishCall(packet.Id())
+	if cancel == nil {
+		// request is cancelled by server
+		return
+	}
+	cancel()
+	if err := cli.send(resp); err != nil {
+		cli.log.Debugf("can't send response of %s: %s", packet.Id(), err.Error())
+	}
+}
+
+// startCall registers running request of server which can be cancelled
+func (cli *RPCClient) startCall(mid string) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cli.callsLock.Lock()
+	cli.calls[mid] = cancel
+	cli.callsLock.Unlock()
+	return ctx
+}
 
+// finishCall unregisters request and returns its cancel function,
+// nil is returned if request is already finished or cancelled
+func (cli *RPCClient) finishCall(mid string) context.CancelFunc {
+	cli.callsLock.Lock()
+	cancel := cli.calls[mid]
+	delete(cli.calls, mid)
+	cli.callsLock.Unlock()
+	return cancel
+}
+
+// cancelCalls cancels all running requests of server
+func (cli *RPCClient) cancelCalls() {
+	cli.callsLock.Lock()
+	calls := cli.calls
+	cli.calls = make(map[string]context.CancelFunc)
+	cli.callsLock.Unlock()
+	for _, cancel := range calls {
+		cancel()
+	}
 }
@@ -1,6 +1,8 @@
 package wsrpc
 
 import (
+	"context"
+	"errors"
 	_ "fmt"
 	"testing"
 	"time"
@@ -65,6 +67,32 @@ func TestRPCBothSide(t *testing.T) {
 		return
 	}
 
+	// check structured errors
+	_, err = cli.Call("MyFind", &SomeReq{"Alice"})
+	var nf *NotFoundError
+	if !errors.As(err, &nf) || nf.Name != "Alice" {
+		t.Errorf("NotFoundError expected, got %v", err)
+		return
+	}
+	if err.Error() != "Alice not found" {
+		t.Error(err)
+		return
+	}
+	_, err = cli.Call("MyFind", &SomeReq{"Eve"})
+	var de *DeniedError
+	if !errors.As(err, &de) || de.Reason != "eavesdropper" {
+		t.Errorf("DeniedError expected, got %v", err)
+		return
+	}
+	if !errors.Is(err, &RemoteError{Code: 403}) {
+		t.Errorf("403 code expected, got %v", err)
+		return
+	}
+	if errors.Is(err, ErrMethodNotFound) {
+		t.Error("unexpected error code")
+		return
+	}
+
 	// check invalid request type
 	type SomeOther struct {
 		Ohoho int
@@ -102,6 +130,35 @@ func TestRPCBothSide(t *testing.T) {
 		return
 	}
 
+	// check call context cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	started := time.Now()
+	_, err = cli.CallContext(ctx, "MySleep", &r)
+	if err != context.Canceled || time.Since(started) > 500*time.Millisecond {
+		t.Errorf("cancelled call expected, got %v in %s", err, time.Since(started))
+		return
+	}
+
+	// check per call deadline overrides client timeout
+	ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	respI, err = cli.CallContext(ctx, "MyWait", &SomeReq{"Bob"})
+	cancel()
+	if err != nil || !respI.(*SomeResp).IsBob {
+		t.Errorf("unexpected result: %v, %v", respI, err)
+		return
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	_, err = cli.CallContext(ctx, "MyWait", &SomeReq{"Bob"})
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("deadline exceeded expected, got %v", err)
+		return
+	}
+
 	// check client helper
 	_, err = ClientWSRPC(&MyProtocol{}, "ws://127.0.0.1:8080/err", 1*time.Second, onNotifFunc, &DummyLogger{})
 	if err == nil {
@@ -119,12 +176,13 @@ func TestRPCBothSide(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 
 	_, err = cli.Call("MyMethod", &r)
-	if err != ClosedConnError {
+	if !errors.Is(err, ClosedConnError) {
 		t.Error(err)
 		return
 	}
 	_, err = cli.Call("MyMethod", &r)
-	if err != ClosedConnError {
+	var cerr *ConnectionClosedError
+	if !errors.As(err, &cerr) || cerr.Err == nil {
 		t.Error(err)
 		return
 	}
@@ -135,6 +193,50 @@ func TestRPCBothSide(t *testing.T) {
 	}
 }
 
+func TestCallCancellation(t *testing.T) {
+	closech := make(chan struct{})
+	ctxErr := make(chan error, 1)
+	go ServeWSRPC(func() SessionProtocol { return &MyProtocol{ctxErr: ctxErr} }, ":8083", "/test/wsrpc", &DummyLogger{LL_INFO}, closech)
+	time.Sleep(100 * time.Millisecond)
+	defer close(closech)
+
+	cli, err := ClientWSRPC(&MyProtocol{}, "ws://127.0.0.1:8083/test/wsrpc", 300*time.Millisecond, nil, &DummyLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	// cancelled call cancels server handler
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	_, err = cli.CallContext(ctx, "MyWait", &SomeReq{"Bob"})
+	if err != context.Canceled {
+		t.Fatalf("cancelled call expected, got %v", err)
+	}
+	select {
+	case err = <-ctxErr:
+		if err != context.Canceled {
+			t.Fatalf("unexpected handler ctx error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler context is not cancelled")
+	}
+
+	// timeouted call cancels server handler
+	_, err = cli.Call("MyWait", &SomeReq{"Bob"})
+	if err != TimeoutError {
+		t.Fatalf("timeout expected, got %v", err)
+	}
+	select {
+	case err = <-ctxErr:
+		if err != context.Canceled {
+			t.Fatalf("unexpected handler ctx error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler context is not cancelled")
+	}
+}
+
 type sprot struct{}
 
 func (p sprot) OnConnect(*RPCConn) {}
@@ -263,3 +365,135 @@ func helperForManyConnsBench(numConns int, b *testing.B) {
 	close(closech)
 	time.Sleep(100 * time.Millisecond)
 }
+
+func TestFailInFlightOnClose(t *testing.T) {
+	closech := make(chan struct{})
+	go ServeWSRPC(func() SessionProtocol { return &MyProtocol{} }, ":8088", "/test/wsrpc", &DummyLogger{}, closech)
+	time.Sleep(100 * time.Millisecond)
+
+	cli, err := ClientWSRPC(&MyProtocol{}, "ws://127.0.0.1:8088/test/wsrpc", 10*time.Second, nil, &DummyLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errch := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := cli.Call("MyWait", &SomeReq{"Bob"})
+			errch <- err
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(closech)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case err = <-errch:
+			var cerr *ConnectionClosedError
+			if !errors.As(err, &cerr) || !errors.Is(err, ClosedConnError) {
+				t.Fatalf("connection closed error expected, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("in-flight call is not failed on close")
+		}
+	}
+
+	// calls on closed client return immediately
+	started := time.Now()
+	_, err = cli.Call("MyMethod", &SomeReq{"Bob"})
+	if !errors.Is(err, ClosedConnError) || time.Since(started) > 10*time.Millisecond {
+		t.Fatalf("immediate connection closed error expected, got %v in %s", err, time.Since(started))
+	}
+}
+
+func TestClientServesServerCalls(t *testing.T) {
+	conn := NewFakeConn()
+	ctxErr := make(chan error, 1)
+	cli, err := NewRPCClient(conn, &MyProtocol{ctxErr: ctxErr}, time.Second, nil, &DummyLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	req := NewPacket(PT_REQUEST, "MyMethod", []byte("{\"name\":\"Bob\"}"))
+	conn.in <- req
+	p := <-conn.out
+	if p.Header.Type != PT_RESPONSE || p.Id() != req.Id() || string(p.Body) != "{\"IsBob\":true}" {
+		t.Fatalf("unexpected response %s", p)
+	}
+
+	req = NewPacket(PT_REQUEST, "Unknown", []byte("{}"))
+	conn.in <- req
+	p = <-conn.out
+	re := parseRemoteError(cli.protDetails, JSONCodec, p.Body)
+	if p.Header.Type != PT_ERROR || p.Id() != req.Id() || re.Code != ErrCodeMethodNotFound {
+		t.Fatalf("unexpected response %s", p)
+	}
+
+	// request cancelled by server
+	req = NewPacket(PT_REQUEST, "MyWait", []byte("{\"name\":\"Bob\"}"))
+	conn.in <- req
+	time.Sleep(50 * time.Millisecond)
+	conn.in <- req.Cancel(context.Canceled)
+	if err := <-ctxErr; err != context.Canceled {
+		t.Fatalf("cancelled context expected, got %v", err)
+	}
+	select {
+	case p = <-conn.out:
+		t.Fatalf("response of cancelled request is not expected, got %s", p)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBidirectionalCalls(t *testing.T) {
+	sessions := make(chan *MyProtocol, 2)
+	typing := make(chan string, 1)
+	closech := make(chan struct{})
+	go ServeWSRPC(func() SessionProtocol {
+		p := &MyProtocol{closed: make(chan bool, 1), typing: typing}
+		sessions <- p
+		return p
+	}, ":8089", "/test/wsrpc", &DummyLogger{}, closech)
+	defer close(closech)
+	<-sessions // protocol instance of NewRPCServer
+	time.Sleep(100 * time.Millisecond)
+
+	cli, err := ClientWSRPC(&MyProtocol{}, "ws://127.0.0.1:8089/test/wsrpc", time.Second, nil, &DummyLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	sess := (<-sessions).conn
+
+	resp, err := sess.Call(context.Background(), "MyFind", &SomeReq{"Bob"})
+	if err != nil || !resp.(*SomeResp).IsBob {
+		t.Fatalf("unexpected result: %v, %v", resp, err)
+	}
+	_, err = sess.Call(context.Background(), "MyFind", &SomeReq{"Alice"})
+	var nf *NotFoundError
+	if !errors.As(err, &nf) || nf.Name != "Alice" {
+		t.Fatalf("declared error expected, got %v", err)
+	}
+
+	// client calls still work
+	resp, err = cli.Call("MyFind", &SomeReq{"Bob"})
+	if err != nil || !resp.(*SomeResp).IsBob {
+		t.Fatalf("unexpected result: %v, %v", resp, err)
+	}
+
+	// client notifications
+	if err := cli.Notify(&TypingNotif{"bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if user := <-typing; user != "bob" {
+		t.Fatalf("unexpected notification from %s", user)
+	}
+	if err := cli.Notify(&MyNotif{}); err == nil || err.Error() != "Notification *wsrpc.MyNotif is not declared in protocol" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cli.Close()
+	time.Sleep(50 * time.Millisecond)
+	if err := cli.Notify(&TypingNotif{"bob"}); !errors.Is(err, ClosedConnError) {
+		t.Fatalf("connection closed error expected, got %v", err)
+	}
+}
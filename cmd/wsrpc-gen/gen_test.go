@@ -0,0 +1,125 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fabregas/wsrpc"
+)
+
+func TestParseProtocol(t *testing.T) {
+	p, err := parsePackage("testdata/sum", "SumProtocol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	methods := []method{
+		{"Count", "SumReq", "SumResp", true, ""},
+		{"Echo", "SumReq", "SumResp", true, "TickNotif"},
+		{"SlowSum", "SumReq", "SumResp", false, ""},
+		{"Sum", "SumReq", "SumResp", false, ""},
+		{"Ticks", "SumReq", "TickNotif", true, ""},
+		{"Upload", "SumReq", "SumResp", false, "TickNotif"},
+	}
+	if !reflect.DeepEqual(p.Methods, methods) {
+		t.Fatalf("unexpected methods %+v", p.Methods)
+	}
+	notifs := []notification{{"ExampleNotif", "ExampleNotif"}, {"TickNotif", "TickNotif"}}
+	if !reflect.DeepEqual(p.Notifications, notifs) {
+		t.Fatalf("unexpected notifications %+v", p.Notifications)
+	}
+	if !reflect.DeepEqual(p.ClientNotifs, []notification{{"TypingNotif", "TypingNotif"}}) {
+		t.Fatalf("unexpected client notifications %+v", p.ClientNotifs)
+	}
+
+	for typeName, msg := range map[string]string{
+		"Unknown":         "type Unknown is not found in testdata/invalid",
+		"NoNotifications": "no Notifications declaration found in session protocol",
+		"BadInput":        "input must be a pointer in method Method",
+		"BadOutput":       "expected response and error as output in method Method",
+		"EmbeddedMethods": "embedded field Base with methods isn't supported",
+		"EmbeddedForeign": "embedded field sync.Mutex from other package isn't supported",
+	} {
+		_, err := parsePackage("testdata/invalid", typeName)
+		if err == nil || err.Error() != msg {
+			t.Errorf("%s: unexpected error %v", typeName, err)
+		}
+	}
+}
+
+func TestGenerateClient(t *testing.T) {
+	p, err := parsePackage("testdata/sum", "SumProtocol")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := generate(p, "sum", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "", src, 0); err != nil {
+		t.Fatalf("invalid generated source: %s\n%s", err, src)
+	}
+	for _, s := range []string{
+		"package sum\n",
+		"func (c *SumProtocolClient) Sum(ctx context.Context, req *SumReq) (*SumResp, error) {",
+		"wsrpc.Invoke[SumReq, SumResp](ctx, c.RPCClient, \"SlowSum\", req)",
+		"func (c *SumProtocolClient) OnTickNotif(handler func(*TickNotif)) error {",
+		"wsrpc.ClientWSRPC(&SumProtocol{}, url, timeout, nil, log, opts...)",
+		"func (c *SumProtocolClient) NotifyTypingNotif(n *TypingNotif) error {",
+		"func (c *SumProtocolClient) Count(ctx context.Context, req *SumReq) iter.Seq2[*SumResp, error] {",
+		"wsrpc.InvokeStream[SumReq, TickNotif](ctx, c.RPCClient, \"Ticks\", req)",
+		"\"iter\"",
+		"func (c *SumProtocolClient) Upload(ctx context.Context, req *SumReq) (*wsrpc.TypedStream[TickNotif, SumResp], error) {",
+		"wsrpc.OpenStream[SumReq, TickNotif, SumResp](ctx, c.RPCClient, \"Echo\", req)",
+	} {
+		if !strings.Contains(string(src), s) {
+			t.Fatalf("%q expected in generated source:\n%s", s, src)
+		}
+	}
+
+	// methods of wsrpc.RPCClient can't be shadowed
+	shadows, err := parsePackage("testdata/invalid", "ShadowsClient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := generate(shadows, "invalid", ""); err == nil || err.Error() != "method Close collides with method of wsrpc.RPCClient" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := parsePackage("testdata/invalid", "EmbeddedPlain"); err != nil {
+		t.Fatalf("embedded struct without methods must be allowed: %v", err)
+	}
+
+	// client in other package
+	if _, err := generate(p, "client", ""); err == nil {
+		t.Fatal("import path of protocol package must be required")
+	}
+	src, err = generate(p, "client", "example.com/sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"package client\n",
+		"\"example.com/sum\"",
+		"func (c *SumProtocolClient) Sum(ctx context.Context, req *sum.SumReq) (*sum.SumResp, error) {",
+		"wsrpc.ClientWSRPC(&sum.SumProtocol{}, url, timeout, nil, log, opts...)",
+	} {
+		if !strings.Contains(string(src), s) {
+			t.Fatalf("%q expected in generated source:\n%s", s, src)
+		}
+	}
+}
+
+func TestRPCClientMethods(t *testing.T) {
+	var methods []string
+	typ := reflect.TypeOf((*wsrpc.RPCClient)(nil))
+	for i := 0; i < typ.NumMethod(); i++ {
+		methods = append(methods, typ.Method(i).Name)
+	}
+	// methods of reflect.Type are sorted by name
+	if !reflect.DeepEqual(rpcClientMethods, methods) {
+		t.Fatalf("rpcClientMethods %v doesn't match methods of wsrpc.RPCClient %v", rpcClientMethods, methods)
+	}
+}
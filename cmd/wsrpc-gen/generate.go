@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const wsrpcImport = "github.com/fabregas/wsrpc"
+
+// rpcClientMethods are exported methods of wsrpc.RPCClient
+var rpcClientMethods = []string{
+	"Call", "CallContext", "Close", "Closed", "Notify", "Stream", "Subscribe", "Unsubscribe",
+}
+
+var clientTemplate = template.Must(template.New("client").Funcs(template.FuncMap{
+	"qualify": func(string) string { return "" }, // replaced in generate
+}).Parse(`// Code generated by wsrpc-gen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- range .Imports}}
+	{{.}}
+{{- end}}
+)
+
+// {{.Client}} is typed client of {{.Protocol}} session protocol
+type {{.Client}} struct {
+	*wsrpc.RPCClient
+}
+
+// New{{.Client}} wraps client created with {{.Protocol}} session protocol
+func New{{.Client}}(cli *wsrpc.RPCClient) *{{.Client}} {
+	return &{{.Client}}{cli}
+}
+
+// Dial{{.Client}} connects to {{.Protocol}} server at url
+func Dial{{.Client}}(url string, timeout time.Duration, log wsrpc.Logger, opts ...wsrpc.Option) (*{{.Client}}, error) {
+	cli, err := wsrpc.ClientWSRPC(&{{qualify .Protocol}}{}, url, timeout, nil, log, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return New{{.Client}}(cli), nil
+}
+{{range .Methods}}
+{{- if .InType}}
+// {{.Name}} opens {{.Name}} stream, items are sent with Send
+func (c *{{$.Client}}) {{.Name}}(ctx context.Context, req *{{qualify .ReqType}}) (*wsrpc.TypedStream[{{qualify .InType}}, {{qualify .OutType}}], error) {
+	return wsrpc.OpenStream[{{qualify .ReqType}}, {{qualify .InType}}, {{qualify .OutType}}](ctx, c.RPCClient, "{{.Name}}", req)
+}
+{{else if .Stream}}
+// {{.Name}} calls remote {{.Name}} streaming method
+func (c *{{$.Client}}) {{.Name}}(ctx context.Context, req *{{qualify .ReqType}}) iter.Seq2[*{{qualify .OutType}}, error] {
+	return wsrpc.InvokeStream[{{qualify .ReqType}}, {{qualify .OutType}}](ctx, c.RPCClient, "{{.Name}}", req)
+}
+{{else}}
+// {{.Name}} calls remote {{.Name}} method
+func (c *{{$.Client}}) {{.Name}}(ctx context.Context, req *{{qualify .ReqType}}) (*{{qualify .OutType}}, error) {
+	return wsrpc.Invoke[{{qualify .ReqType}}, {{qualify .OutType}}](ctx, c.RPCClient, "{{.Name}}", req)
+}
+{{end}}
+{{- end}}
+{{- range .Notifications}}
+// On{{.Name}} registers handler of {{.Name}} notification
+func (c *{{$.Client}}) On{{.Name}}(handler func(*{{qualify .Type}})) error {
+	return wsrpc.OnNotification(c.RPCClient, handler)
+}
+{{end}}
+{{- range .ClientNotifs}}
+// Notify{{.Name}} sends {{.Name}} notification to server
+func (c *{{$.Client}}) Notify{{.Name}}(n *{{qualify .Type}}) error {
+	return c.RPCClient.Notify(n)
+}
+{{end}}`))
+
+// generate returns source of typed client of protocol p in package pkg,
+// protImport is import path of protocol package if pkg differs from it
+func generate(p *protocol, pkg, protImport string) ([]byte, error) {
+	external := pkg != p.Package
+	if external && protImport == "" {
+		return nil, fmt.Errorf("import path of protocol package is required to generate client in other package")
+	}
+
+	data := struct {
+		Package       string
+		Imports       []string
+		Client        string
+		Protocol      string
+		Methods       []method
+		Notifications []notification
+		ClientNotifs  []notification
+	}{
+		Package:       pkg,
+		Client:        p.Name + "Client",
+		Protocol:      p.Name,
+		Methods:       p.Methods,
+		Notifications: p.Notifications,
+		ClientNotifs:  p.ClientNotifs,
+	}
+
+	// check generated method names don't collide,
+	// methods of embedded *wsrpc.RPCClient must not be shadowed
+	names := map[string]bool{"RPCClient": true}
+	for _, name := range rpcClientMethods {
+		names[name] = true
+	}
+	for _, m := range p.Methods {
+		if names[m.Name] {
+			return nil, fmt.Errorf("method %s collides with method of wsrpc.RPCClient", m.Name)
+		}
+		names[m.Name] = true
+	}
+	for _, n := range p.Notifications {
+		if names["On"+n.Name] {
+			return nil, fmt.Errorf("handler On%s of notification %s collides with method", n.Name, n.Name)
+		}
+		names["On"+n.Name] = true
+	}
+	for _, n := range p.ClientNotifs {
+		if names["Notify"+n.Name] {
+			return nil, fmt.Errorf("Notify%s of client notification %s collides with method", n.Name, n.Name)
+		}
+		names["Notify"+n.Name] = true
+	}
+
+	imports := map[string]string{
+		"context": "context",
+		"time":    "time",
+		"wsrpc":   wsrpcImport,
+	}
+	for name, path := range p.Imports {
+		imports[name] = path
+	}
+	for _, m := range p.Methods {
+		if m.Stream && m.InType == "" {
+			imports["iter"] = "iter"
+		}
+	}
+	if external {
+		imports[p.Package] = protImport
+	}
+	for name, path := range imports {
+		imp := fmt.Sprintf("%q", path)
+		if path[strings.LastIndex(path, "/")+1:] != name {
+			imp = name + " " + imp
+		}
+		data.Imports = append(data.Imports, imp)
+	}
+	sort.Strings(data.Imports)
+
+	qualify := func(t string) string {
+		if external && !strings.Contains(t, ".") {
+			return p.Package + "." + t
+		}
+		return t
+	}
+
+	buf := &bytes.Buffer{}
+	tmpl := template.Must(clientTemplate.Clone()).Funcs(template.FuncMap{"qualify": qualify})
+	if err := tmpl.Execute(buf, data); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
@@ -0,0 +1,62 @@
+// Command wsrpc-gen generates typed client of wsrpc session protocol.
+//
+// Protocol type is loaded from go sources using the same rules as
+// wsrpc server and client, generated client has one method per RPC
+// method (server streaming methods return iter.Seq2 of items, client
+// streaming and bidirectional ones return wsrpc.TypedStream) and
+// On<Notification> handlers registration. Methods colliding with methods
+// of embedded *wsrpc.RPCClient and embedded fields which may promote methods
+// are rejected:
+//
+//	//go:generate wsrpc-gen -type SumProtocol
+//
+//	cli, err := DialSumProtocolClient(url, 5*time.Second, log)
+//	resp, err := cli.Sum(ctx, &SumReq{12, 44})
+//	err = cli.OnExampleNotif(func(n *ExampleNotif) { ... })
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	typeName := flag.String("type", "", "session protocol type name (required)")
+	dir := flag.String("dir", ".", "directory of protocol package")
+	output := flag.String("o", "", "output file (<type>_client.go in protocol directory by default)")
+	pkg := flag.String("pkg", "", "package of generated client (protocol package by default)")
+	protImport := flag.String("import", "", "import path of protocol package, required if -pkg differs from it")
+	flag.Parse()
+
+	if *typeName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	p, err := parsePackage(*dir, *typeName)
+	if err != nil {
+		fail(err)
+	}
+	if *pkg == "" {
+		*pkg = p.Package
+	}
+	src, err := generate(p, *pkg, *protImport)
+	if err != nil {
+		fail(err)
+	}
+
+	if *output == "" {
+		*output = filepath.Join(*dir, strings.ToLower(*typeName)+"_client.go")
+	}
+	if err := os.WriteFile(*output, src, 0644); err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "wsrpc-gen: %s\n", err.Error())
+	os.Exit(1)
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type method struct {
+	Name    string
+	ReqType string
+	OutType string // response type or item type of streaming method
+	Stream  bool   // method streams items to client
+	InType  string // type of items streamed by client, empty if method doesn't receive them
+}
+
+type notification struct {
+	Name string // notification name is name of its type
+	Type string
+}
+
+type protocol struct {
+	Package       string
+	Name          string
+	Methods       []method
+	Notifications []notification
+	ClientNotifs  []notification
+	Imports       map[string]string // packages of request, response and notification types
+}
+
+type methodDecl struct {
+	decl    *ast.FuncDecl
+	imports map[string]string // imports of method file by package name
+}
+
+// parsePackage parses go package in dir and returns protocol
+// description of typeName. It follows the same rules as
+// wsrpc.parseSessionProtocol but works on source code.
+func parsePackage(dir, typeName string) (*protocol, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi fs.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pkg := range pkgs {
+		if p, err := parseProtocol(pkg, typeName); p != nil || err != nil {
+			return p, err
+		}
+	}
+	return nil, fmt.Errorf("type %s is not found in %s", typeName, dir)
+}
+
+func parseProtocol(pkg *ast.Package, typeName string) (*protocol, error) {
+	structs := make(map[string]*ast.StructType)
+	types := make(map[string]bool)
+	withMethods := make(map[string]bool) // types with exported methods
+	var decls []methodDecl
+	var protImports map[string]string
+
+	for _, f := range pkg.Files {
+		imports := fileImports(f)
+		for _, d := range f.Decls {
+			switch d := d.(type) {
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					ts, ok := spec.(*ast.TypeSpec)
+					if !ok {
+						continue
+					}
+					types[ts.Name.Name] = true
+					if st, ok := ts.Type.(*ast.StructType); ok {
+						structs[ts.Name.Name] = st
+						if ts.Name.Name == typeName {
+							protImports = imports
+						}
+					}
+				}
+			case *ast.FuncDecl:
+				if d.Recv != nil && ast.IsExported(d.Name.Name) {
+					withMethods[receiverName(d.Recv)] = true
+				}
+				if d.Recv != nil && receiverName(d.Recv) == typeName {
+					decls = append(decls, methodDecl{d, imports})
+				}
+			}
+		}
+	}
+
+	st, ok := structs[typeName]
+	if !ok {
+		return nil, nil
+	}
+	if err := checkEmbedded(st, structs, types, withMethods); err != nil {
+		return nil, err
+	}
+	ret := &protocol{Package: pkg.Name, Name: typeName, Imports: make(map[string]string)}
+
+	notifs, err := parseNotifications(st, "Notifications", true)
+	if err != nil {
+		return nil, err
+	}
+	ret.Notifications = notifs
+	clientNotifs, err := parseNotifications(st, "ClientNotifications", false)
+	if err != nil {
+		return nil, err
+	}
+	ret.ClientNotifs = clientNotifs
+	handlers := make(map[string]bool)
+	for _, n := range clientNotifs {
+		handlers["On"+n.Name] = true
+	}
+	for _, n := range append(notifs, clientNotifs...) {
+		if err := ret.addImport(n.Type, protImports); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, d := range decls {
+		if handlers[d.decl.Name.Name] {
+			// handler of client notification
+			continue
+		}
+		m, err := parseMethod(d.decl, d.imports, structs)
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			continue
+		}
+		ret.Methods = append(ret.Methods, *m)
+		for _, t := range []string{m.ReqType, m.OutType, m.InType} {
+			if err := ret.addImport(t, d.imports); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Slice(ret.Methods, func(i, j int) bool { return ret.Methods[i].Name < ret.Methods[j].Name })
+	return ret, nil
+}
+
+// checkEmbedded rejects embedded fields which may promote methods to protocol,
+// they are registered by wsrpc.parseSessionProtocol but not declared on the type.
+// Embedded structs of the same package without exported methods are allowed.
+func checkEmbedded(st *ast.StructType, structs map[string]*ast.StructType, types, withMethods map[string]bool) error {
+	for _, f := range st.Fields.List {
+		if len(f.Names) > 0 {
+			continue
+		}
+		t := f.Type
+		if star, ok := t.(*ast.StarExpr); ok {
+			t = star.X
+		}
+		id, ok := t.(*ast.Ident)
+		if !ok || !types[id.Name] {
+			return fmt.Errorf("embedded field %s from other package isn't supported", typeString(f.Type))
+		}
+		est, ok := structs[id.Name]
+		if !ok || withMethods[id.Name] {
+			return fmt.Errorf("embedded field %s with methods isn't supported", id.Name)
+		}
+		if err := checkEmbedded(est, structs, types, withMethods); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addImport records import of package of type t declared in other package
+func (p *protocol) addImport(t string, imports map[string]string) error {
+	i := strings.Index(t, ".")
+	if i < 0 {
+		return nil
+	}
+	name := t[:i]
+	path, ok := imports[name]
+	if !ok {
+		return fmt.Errorf("unknown package %s of type %s", name, t)
+	}
+	if prev, ok := p.Imports[name]; ok && prev != path {
+		return fmt.Errorf("package name %s is used for %s and %s", name, prev, path)
+	}
+	p.Imports[name] = path
+	return nil
+}
+
+// parseNotifications parses notifications declared in field of protocol struct
+func parseNotifications(st *ast.StructType, fieldName string, required bool) ([]notification, error) {
+	var field *ast.Field
+	for _, f := range st.Fields.List {
+		for _, n := range f.Names {
+			if n.Name == fieldName {
+				field = f
+			}
+		}
+	}
+	if field == nil {
+		if !required {
+			return []notification{}, nil
+		}
+		return nil, fmt.Errorf("no %s declaration found in session protocol", fieldName)
+	}
+	ns, ok := field.Type.(*ast.StructType)
+	if !ok {
+		return nil, fmt.Errorf("%s must be declared as a struct", fieldName)
+	}
+
+	ret := []notification{}
+	for _, f := range ns.Fields.List {
+		star, ok := f.Type.(*ast.StarExpr)
+		if !ok {
+			return nil, fmt.Errorf("notification %s must be a pointer", typeString(f.Type))
+		}
+		n := notification{Type: typeString(star.X)}
+		n.Name = n.Type[strings.LastIndex(n.Type, ".")+1:]
+		ret = append(ret, n)
+	}
+	return ret, nil
+}
+
+// parseMethod returns nil method for methods which are not RPC methods
+func parseMethod(d *ast.FuncDecl, imports map[string]string, structs map[string]*ast.StructType) (*method, error) {
+	name := d.Name.Name
+	if !ast.IsExported(name) || name == "OnConnect" || name == "OnDisconnect" {
+		return nil, nil
+	}
+
+	// check inputs
+	params := expandFields(d.Type.Params)
+	if name == "Phases" && len(params) == 0 {
+		// phases declaration of wsrpc.PhasedSession
+		return nil, nil
+	}
+	var recvItem, sendItem ast.Expr
+	isStream := false
+	if len(params) > 1 {
+		recvItem, sendItem, isStream = streamTypes(params[len(params)-1], imports)
+		if isStream {
+			params = params[:len(params)-1]
+		}
+	}
+	withCtx := false
+	if len(params) == 2 {
+		if sel, ok := params[0].(*ast.SelectorExpr); ok && sel.Sel.Name == "Context" {
+			id, ok := sel.X.(*ast.Ident)
+			withCtx = ok && imports[id.Name] == "context"
+		}
+	}
+	if len(params) != 1 && !withCtx {
+		return nil, fmt.Errorf("one input structure expected in method %s", name)
+	}
+	in, ok := params[len(params)-1].(*ast.StarExpr)
+	if !ok {
+		return nil, fmt.Errorf("input must be a pointer in method %s", name)
+	}
+	if !isStruct(in.X, structs) {
+		return nil, fmt.Errorf("input must be a pointer to struct in method %s", name)
+	}
+
+	// check outputs
+	results := expandFields(d.Type.Results)
+	ret := &method{Name: name, ReqType: typeString(in.X)}
+	for _, item := range []ast.Expr{recvItem, sendItem} {
+		if item == nil {
+			continue
+		}
+		if star, ok := item.(*ast.StarExpr); !ok || !isStruct(star.X, structs) {
+			return nil, fmt.Errorf("stream item must be a pointer to struct in method %s", name)
+		}
+	}
+	if recvItem != nil {
+		ret.InType = typeString(recvItem.(*ast.StarExpr).X)
+	}
+	if sendItem != nil {
+		if len(results) != 1 || typeString(results[0]) != "error" {
+			return nil, fmt.Errorf("stream method must return only error in method %s", name)
+		}
+		ret.OutType = typeString(sendItem.(*ast.StarExpr).X)
+		ret.Stream = true
+		return ret, nil
+	}
+	if len(results) == 2 && !isStream {
+		if ch, ok := results[0].(*ast.ChanType); ok && ch.Dir&ast.RECV != 0 {
+			// items are received from returned channel
+			results[0] = ch.Value
+			ret.Stream = true
+		}
+	}
+	if len(results) != 2 {
+		return nil, fmt.Errorf("expected response and error as output in method %s", name)
+	}
+	out, ok := results[0].(*ast.StarExpr)
+	if !ok {
+		return nil, fmt.Errorf("output must be a pointer in method %s", name)
+	}
+	if !isStruct(out.X, structs) {
+		return nil, fmt.Errorf("output must be a pointer to struct in method %s", name)
+	}
+	if typeString(results[1]) != "error" {
+		return nil, fmt.Errorf("method must return error type in method %s", name)
+	}
+
+	ret.OutType = typeString(out.X)
+	return ret, nil
+}
+
+// streamTypes returns item types of wsrpc stream parameter,
+// false is returned for other types
+func streamTypes(expr ast.Expr, imports map[string]string) (recv, send ast.Expr, ok bool) {
+	var x ast.Expr
+	var indices []ast.Expr
+	switch t := expr.(type) {
+	case *ast.IndexExpr:
+		x, indices = t.X, []ast.Expr{t.Index}
+	case *ast.IndexListExpr:
+		x, indices = t.X, t.Indices
+	default:
+		return nil, nil, false
+	}
+	sel, ok := x.(*ast.SelectorExpr)
+	if !ok {
+		return nil, nil, false
+	}
+	if pkg, ok := sel.X.(*ast.Ident); !ok || imports[pkg.Name] != wsrpcImport {
+		return nil, nil, false
+	}
+	switch {
+	case sel.Sel.Name == "Stream" && len(indices) == 1:
+		return nil, indices[0], true
+	case sel.Sel.Name == "RecvStream" && len(indices) == 1:
+		return indices[0], nil, true
+	case sel.Sel.Name == "BidiStream" && len(indices) == 2:
+		return indices[0], indices[1], true
+	}
+	return nil, nil, false
+}
+
+// isStruct checks local type is struct, types of other packages
+// can't be resolved without type checking and are accepted as is
+func isStruct(expr ast.Expr, structs map[string]*ast.StructType) bool {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		_, ok := structs[t.Name]
+		return ok
+	case *ast.SelectorExpr:
+		return true
+	}
+	return false
+}
+
+func expandFields(fl *ast.FieldList) []ast.Expr {
+	ret := []ast.Expr{}
+	if fl == nil {
+		return ret
+	}
+	for _, f := range fl.List {
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			ret = append(ret, f.Type)
+		}
+	}
+	return ret
+}
+
+func receiverName(recv *ast.FieldList) string {
+	if len(recv.List) != 1 {
+		return ""
+	}
+	t := recv.List[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	if id, ok := t.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+// fileImports returns import paths of file by package name,
+// package name is assumed to be the last element of import path
+func fileImports(f *ast.File) map[string]string {
+	ret := make(map[string]string)
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		name := path[strings.LastIndex(path, "/")+1:]
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		ret[name] = path
+	}
+	return ret
+}
+
+func typeString(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		return typeString(t.X) + "." + t.Sel.Name
+	case *ast.StarExpr:
+		return "*" + typeString(t.X)
+	}
+	return fmt.Sprintf("%T", expr)
+}
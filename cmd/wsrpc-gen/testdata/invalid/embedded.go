@@ -0,0 +1,36 @@
+package invalid
+
+import "sync"
+
+type Base struct{}
+
+func (b *Base) Reset(req *Req) (*Req, error) {
+	return nil, nil
+}
+
+type EmbeddedMethods struct {
+	*Base
+	Notifications struct{}
+}
+
+type EmbeddedForeign struct {
+	sync.Mutex
+	Notifications struct{}
+}
+
+type Plain struct {
+	Name string
+}
+
+type EmbeddedPlain struct {
+	Plain
+	Notifications struct{}
+}
+
+type ShadowsClient struct {
+	Notifications struct{}
+}
+
+func (p *ShadowsClient) Close(req *Req) (*Req, error) {
+	return nil, nil
+}
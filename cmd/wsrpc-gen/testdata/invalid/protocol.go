@@ -0,0 +1,21 @@
+package invalid
+
+type Req struct{}
+
+type NoNotifications struct{}
+
+type BadInput struct {
+	Notifications struct{}
+}
+
+func (p *BadInput) Method(req Req) (*Req, error) {
+	return nil, nil
+}
+
+type BadOutput struct {
+	Notifications struct{}
+}
+
+func (p *BadOutput) Method(req *Req) *Req {
+	return nil
+}
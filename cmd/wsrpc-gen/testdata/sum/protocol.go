@@ -0,0 +1,74 @@
+package sum
+
+import (
+	ctx "context"
+	"time"
+
+	"github.com/fabregas/wsrpc"
+)
+
+type SumReq struct {
+	A int
+	B int
+}
+
+type SumResp struct {
+	Sum int
+}
+
+type ExampleNotif struct {
+	Msg string
+}
+
+type TickNotif struct {
+	At time.Time
+}
+
+type TypingNotif struct {
+	User string
+}
+
+type SumProtocol struct {
+	Notifications struct {
+		*ExampleNotif
+		*TickNotif
+	}
+	ClientNotifications struct {
+		*TypingNotif
+	}
+}
+
+func (p *SumProtocol) OnTypingNotif(n *TypingNotif) {}
+
+func (p *SumProtocol) OnConnect(conn *wsrpc.RPCConn) {}
+func (p *SumProtocol) OnDisconnect(err error)        {}
+
+func (p *SumProtocol) Phases() wsrpc.SessionPhases {
+	return wsrpc.SessionPhases{Initial: "ready", Methods: map[wsrpc.Phase][]string{"ready": {"Sum"}}}
+}
+
+func (p *SumProtocol) Sum(req *SumReq) (*SumResp, error) {
+	return &SumResp{req.A + req.B}, nil
+}
+
+func (p *SumProtocol) SlowSum(c ctx.Context, req *SumReq) (*SumResp, error) {
+	return p.Sum(req)
+}
+
+func (p *SumProtocol) Count(c ctx.Context, req *SumReq, s wsrpc.Stream[*SumResp]) error {
+	return nil
+}
+
+func (p *SumProtocol) Ticks(req *SumReq) (<-chan *TickNotif, error) {
+	return nil, nil
+}
+
+func (p *SumProtocol) Upload(req *SumReq, s wsrpc.RecvStream[*TickNotif]) (*SumResp, error) {
+	return nil, nil
+}
+
+func (p *SumProtocol) Echo(c ctx.Context, req *SumReq, s wsrpc.BidiStream[*TickNotif, *SumResp]) error {
+	return nil
+}
+
+func (p *SumProtocol) helper() {}
@@ -0,0 +1,103 @@
+package wsrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// subprotocolPrefix prefixes codec name in websocket subprotocol handshake
+const subprotocolPrefix = "wsrpc."
+
+// Codec represents marshaller of requests, responses and notifications bodies
+type Codec interface {
+	// Name returns unique codec name used in connection handshake
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// built-in codecs
+var (
+	JSONCodec     Codec = jsonCodec{}
+	MsgpackCodec  Codec = msgpackCodec{}
+	CBORCodec     Codec = cborCodec{}
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string                               { return "msgpack" }
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+type cborCodec struct{}
+
+func (cborCodec) Name() string                               { return "cbor" }
+func (cborCodec) Marshal(v interface{}) ([]byte, error)      { return cbor.Marshal(v) }
+func (cborCodec) Unmarshal(data []byte, v interface{}) error { return cbor.Unmarshal(data, v) }
+
+// protobufCodec works only with types implementing proto.Message
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string { return "protobuf" }
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf: %T does not implement proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf: %T does not implement proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func codecSubprotocols(codecs []Codec) []string {
+	ret := make([]string, 0, len(codecs))
+	for _, c := range codecs {
+		ret = append(ret, subprotocolPrefix+c.Name())
+	}
+	return ret
+}
+
+func codecNameFromSubprotocol(sp string) string {
+	if !strings.HasPrefix(sp, subprotocolPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(sp, subprotocolPrefix)
+}
+
+// selectCodec returns codec agreed with remote side of transport.
+// JSON codec is used if transport does not negotiate codecs (legacy peers).
+func selectCodec(codecs []Codec, tr RPCTransport) (Codec, error) {
+	cn, ok := tr.(CodecNegotiator)
+	if !ok {
+		return JSONCodec, nil
+	}
+	name := cn.CodecName()
+	if name == "" {
+		return JSONCodec, nil
+	}
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported codec %s", name)
+}
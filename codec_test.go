@@ -0,0 +1,118 @@
+package wsrpc
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type fakeNegotiator struct {
+	FakeConn
+	name string
+}
+
+func (c *fakeNegotiator) CodecName() string { return c.name }
+
+func TestCodecsRoundTrip(t *testing.T) {
+	for _, c := range []Codec{JSONCodec, MsgpackCodec, CBORCodec} {
+		buf, err := c.Marshal(&SomeReq{"Bob"})
+		if err != nil {
+			t.Fatalf("%s: %s", c.Name(), err)
+		}
+		r := &SomeReq{}
+		if err = c.Unmarshal(buf, r); err != nil {
+			t.Fatalf("%s: %s", c.Name(), err)
+		}
+		if r.Name != "Bob" {
+			t.Fatalf("%s: unexpected value %v", c.Name(), r)
+		}
+	}
+
+	buf, err := ProtobufCodec.Marshal(wrapperspb.String("Bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sv := &wrapperspb.StringValue{}
+	if err = ProtobufCodec.Unmarshal(buf, sv); err != nil {
+		t.Fatal(err)
+	}
+	if sv.Value != "Bob" {
+		t.Fatalf("unexpected value %v", sv)
+	}
+
+	_, err = ProtobufCodec.Marshal(&SomeReq{})
+	if err == nil || err.Error() != "protobuf: *wsrpc.SomeReq does not implement proto.Message" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = ProtobufCodec.Unmarshal(buf, &SomeReq{})
+	if err == nil || err.Error() != "protobuf: *wsrpc.SomeReq does not implement proto.Message" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSelectCodec(t *testing.T) {
+	codecs := []Codec{MsgpackCodec, JSONCodec}
+
+	c, err := selectCodec(codecs, NewFakeConn())
+	if err != nil || c != JSONCodec {
+		t.Fatalf("JSON expected for legacy transport, got %v (%v)", c, err)
+	}
+	c, err = selectCodec(codecs, &fakeNegotiator{name: ""})
+	if err != nil || c != JSONCodec {
+		t.Fatalf("JSON expected if nothing negotiated, got %v (%v)", c, err)
+	}
+	c, err = selectCodec(codecs, &fakeNegotiator{name: "msgpack"})
+	if err != nil || c != MsgpackCodec {
+		t.Fatalf("msgpack expected, got %v (%v)", c, err)
+	}
+	_, err = selectCodec(codecs, &fakeNegotiator{name: "cbor"})
+	if err == nil || err.Error() != "unsupported codec cbor" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCodecNegotiation(t *testing.T) {
+	closech := make(chan struct{})
+	go ServeWSRPC(
+		func() SessionProtocol { return &MyProtocol{} },
+		":8082", "/test/wsrpc", &DummyLogger{LL_INFO}, closech,
+		WithCodecs(CBORCodec, MsgpackCodec, JSONCodec),
+	)
+	time.Sleep(100 * time.Millisecond)
+	defer close(closech)
+
+	for _, c := range []Codec{MsgpackCodec, CBORCodec, JSONCodec} {
+		notifch := make(chan interface{}, 1)
+		onNotifFunc := func(n interface{}, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			notifch <- n
+		}
+		cli, err := ClientWSRPC(&MyProtocol{}, "ws://127.0.0.1:8082/test/wsrpc", time.Second, onNotifFunc, &DummyLogger{}, WithCodecs(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cli.codec != c {
+			t.Fatalf("%s codec expected, got %s", c.Name(), cli.codec.Name())
+		}
+		if tr := cli.conn.(*WsTransport); tr.CodecName() != c.Name() {
+			t.Fatalf("%s codec expected in handshake, got %s", c.Name(), tr.CodecName())
+		}
+
+		n := (<-notifch).(*MyNotif)
+		if n.Msg != "hello, dude!" {
+			t.Fatalf("%s: unexpected notification %v", c.Name(), n)
+		}
+
+		resp, err := cli.Call("MyMethod", &SomeReq{"Bob"})
+		if err != nil {
+			t.Fatalf("%s: %s", c.Name(), err)
+		}
+		if !resp.(*SomeResp).IsBob {
+			t.Fatalf("%s: unexpected response %v", c.Name(), resp)
+		}
+		cli.Close()
+	}
+}
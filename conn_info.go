@@ -0,0 +1,129 @@
+package wsrpc
+
+import (
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ConnInfo describes connection of session, headers and query
+// must not be modified
+type ConnInfo struct {
+	// RemoteAddr is IP address of client, it is taken from Forwarded or
+	// X-Forwarded-For headers if request came through trusted proxies
+	RemoteAddr string
+	// PeerAddr is host:port of connection peer (client or proxy)
+	PeerAddr string
+
+	Header      http.Header // handshake request headers
+	Path        string
+	Query       url.Values
+	Subprotocol string // negotiated websocket subprotocol
+
+	PeerCertificates []*x509.Certificate // TLS certificates of client
+	ConnectedAt      time.Time
+}
+
+// WithTrustedProxies sets networks of proxies whose Forwarded and
+// X-Forwarded-For headers are used to find client address
+func WithTrustedProxies(prefixes ...netip.Prefix) Option {
+	return func(o *options) {
+		o.trustedProxies = prefixes
+	}
+}
+
+// WithSessionFactory sets function creating session protocol with info about
+// connection, it replaces NewSessionFunc of server. If NewSessionFunc is nil,
+// session created with empty ConnInfo is used to parse session protocol.
+func WithSessionFactory(f func(info ConnInfo) SessionProtocol) Option {
+	return func(o *options) {
+		o.sessionFactory = f
+	}
+}
+
+// newConnInfo returns info about websocket connection established by request r
+func newConnInfo(r *http.Request, subprotocol string, trusted []netip.Prefix) ConnInfo {
+	info := ConnInfo{
+		RemoteAddr:  resolveRemoteAddr(r, trusted),
+		PeerAddr:    r.RemoteAddr,
+		Header:      r.Header.Clone(),
+		Path:        r.URL.Path,
+		Query:       r.URL.Query(),
+		Subprotocol: subprotocol,
+		ConnectedAt: time.Now(),
+	}
+	if r.TLS != nil {
+		info.PeerCertificates = r.TLS.PeerCertificates
+	}
+	return info
+}
+
+// resolveRemoteAddr returns client address. Proxy headers are walked from
+// the nearest hop while addresses belong to trusted proxies, the first
+// untrusted address is client one.
+func resolveRemoteAddr(r *http.Request, trusted []netip.Prefix) string {
+	peer, ok := parseAddr(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr
+	}
+	hops := forwardedFor(r.Header)
+	for i := len(hops) - 1; i >= 0 && isTrusted(peer, trusted); i-- {
+		addr, ok := parseAddr(hops[i])
+		if !ok {
+			// obfuscated or unknown hop, the nearest valid one is used
+			break
+		}
+		peer = addr
+	}
+	return peer.String()
+}
+
+// forwardedFor returns client and proxies addresses from proxy headers,
+// the nearest hop is the last one. Forwarded header is preferred.
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	for _, v := range h.Values("Forwarded") {
+		for _, elem := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					hops = append(hops, strings.Trim(val, "\""))
+				}
+			}
+		}
+	}
+	if len(hops) > 0 {
+		return hops
+	}
+	for _, v := range h.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(addr))
+		}
+	}
+	return hops
+}
+
+// parseAddr parses IP address with optional port, IPv6 may be in brackets
+func parseAddr(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package wsrpc
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestResolveRemoteAddr(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}
+	for _, tc := range []struct {
+		peer    string
+		header  http.Header
+		trusted []netip.Prefix
+		addr    string
+	}{
+		{"10.0.0.1:5000", http.Header{"X-Forwarded-For": {"203.0.113.7"}}, nil, "10.0.0.1"},
+		{"192.0.2.1:5000", http.Header{"X-Forwarded-For": {"203.0.113.7"}}, trusted, "192.0.2.1"},
+		{"10.0.0.1:5000", http.Header{"X-Forwarded-For": {"203.0.113.7, 10.1.1.1"}}, trusted, "203.0.113.7"},
+		{"10.0.0.1:5000", http.Header{"X-Forwarded-For": {"198.51.100.1", "203.0.113.7, 10.1.1.1"}}, trusted, "203.0.113.7"},
+		{"10.0.0.1:5000", http.Header{"X-Forwarded-For": {"10.2.2.2, 10.1.1.1"}}, trusted, "10.2.2.2"},
+		{"10.0.0.1:5000", http.Header{"X-Forwarded-For": {"unknown, 10.1.1.1"}}, trusted, "10.1.1.1"},
+		{"[fd00::1]:5000", http.Header{
+			"Forwarded":       {`for="[2001:db8::17]:4711";proto=https, for=10.1.1.1;by=10.0.0.1`},
+			"X-Forwarded-For": {"198.51.100.1"},
+		}, trusted, "2001:db8::17"},
+		{"pipe", nil, trusted, "pipe"},
+	} {
+		r := &http.Request{RemoteAddr: tc.peer, Header: tc.header}
+		if addr := resolveRemoteAddr(r, tc.trusted); addr != tc.addr {
+			t.Fatalf("%s %v: %s expected, got %s", tc.peer, tc.header, tc.addr, addr)
+		}
+	}
+}
+
+func TestSessionConnInfo(t *testing.T) {
+	infos := make(chan ConnInfo, 1)
+	factory := func(info ConnInfo) SessionProtocol {
+		infos <- info
+		return &MyProtocol{}
+	}
+	closech := make(chan struct{})
+	go ServeWSRPC(
+		nil, ":8094", "/test/wsrpc", &DummyLogger{}, closech,
+		WithSessionFactory(factory), WithTrustedProxies(netip.MustParsePrefix("127.0.0.0/8")),
+	)
+	// protocol is parsed from session with empty info
+	if info := <-infos; !info.ConnectedAt.IsZero() {
+		t.Fatalf("empty info expected, got %v", info)
+	}
+	time.Sleep(100 * time.Millisecond)
+	defer close(closech)
+
+	header := http.Header{"X-Forwarded-For": {"203.0.113.7"}, "X-Client": {"test"}}
+	cli, err := ClientWSRPC(
+		&MyProtocol{}, "ws://127.0.0.1:8094/test/wsrpc?room=lobby", time.Second, nil, &DummyLogger{},
+		WithHandshakeHeader(header),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	info := <-infos
+	if info.RemoteAddr != "203.0.113.7" || info.Header.Get("X-Client") != "test" ||
+		info.Path != "/test/wsrpc" || info.Query.Get("room") != "lobby" ||
+		info.Subprotocol != "wsrpc.json" || info.ConnectedAt.IsZero() {
+		t.Fatalf("unexpected conn info %+v", info)
+	}
+	if peer, ok := parseAddr(info.PeerAddr); !ok || !peer.IsLoopback() {
+		t.Fatalf("loopback peer expected, got %s", info.PeerAddr)
+	}
+
+}
@@ -1,7 +1,12 @@
 package wsrpc
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,13 +25,56 @@ type MyNotif struct {
 	Msg string
 }
 
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string { return e.Name + " not found" }
+
+type DeniedError struct {
+	Reason string
+}
+
+func (e *DeniedError) Error() string        { return "denied: " + e.Reason }
+func (e *DeniedError) ErrorCode() ErrorCode { return ErrorCode(403) }
+
+type CountItem struct {
+	N int
+}
+
+type TypingNotif struct {
+	User string
+}
+
 type MyProtocol struct {
 	closed chan bool
+	ctxErr chan error
+	typing chan string
 	conn   *RPCConn
 
+	mu      sync.Mutex
+	journal []string
+
 	Notifications struct {
 		*MyNotif
 	}
+	ClientNotifications struct {
+		*TypingNotif
+	}
+	Errors struct {
+		*NotFoundError
+		*DeniedError
+	}
+}
+
+// OnTypingNotif handles client notification
+func (p *MyProtocol) OnTypingNotif(n *TypingNotif) {
+	if n.User == "panic" {
+		panic("typing panic")
+	}
+	if p.typing != nil {
+		p.typing <- n.User
+	}
 }
 
 func (p *MyProtocol) OnConnect(conn *RPCConn) {
@@ -48,11 +96,129 @@ func (p *MyProtocol) MyMethod(req *SomeReq) (*SomeResp, error) {
 		return &SomeResp{false}, nil
 	}
 }
+func (p *MyProtocol) MyFind(req *SomeReq) (*SomeResp, error) {
+	switch req.Name {
+	case "Bob":
+		return &SomeResp{true}, nil
+	case "Eve":
+		return nil, fmt.Errorf("find failed: %w", &DeniedError{"eavesdropper"})
+	default:
+		return nil, &NotFoundError{req.Name}
+	}
+}
 func (p *MyProtocol) MySleep(req *SomeReq) (*SomeResp, error) {
 	time.Sleep(2 * time.Second)
 	return &SomeResp{false}, nil
 }
 
+func (p *MyProtocol) MyWait(ctx context.Context, req *SomeReq) (*SomeResp, error) {
+	select {
+	case <-ctx.Done():
+		if p.ctxErr != nil {
+			p.ctxErr <- ctx.Err()
+		}
+		return nil, ctx.Err()
+	case <-time.After(2 * time.Second):
+		return &SomeResp{req.Name == "Bob"}, nil
+	}
+}
+
+// MyAppend appends name to session journal, "slow" names are appended with delay
+func (p *MyProtocol) MyAppend(req *SomeReq) (*SomeResp, error) {
+	if strings.HasPrefix(req.Name, "slow") {
+		time.Sleep(50 * time.Millisecond)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.journal = append(p.journal, req.Name)
+	return &SomeResp{}, nil
+}
+
+func (p *MyProtocol) MyPanic(req *SomeReq) (*SomeResp, error) {
+	var m map[string]int
+	m[req.Name] = 1
+	return &SomeResp{}, nil
+}
+
+// MyCount streams items 1..N where N is request name, "fail" streams 2 items and fails
+func (p *MyProtocol) MyCount(ctx context.Context, req *SomeReq, s Stream[*CountItem]) error {
+	n, err := strconv.Atoi(req.Name)
+	if req.Name == "fail" {
+		n, err = 2, &NotFoundError{req.Name}
+	}
+	for i := 1; i <= n; i++ {
+		if serr := s.Send(&CountItem{i}); serr != nil {
+			if p.ctxErr != nil {
+				p.ctxErr <- serr
+			}
+			return serr
+		}
+	}
+	return err
+}
+
+// MyTicks streams N items from channel
+func (p *MyProtocol) MyTicks(req *SomeReq) (<-chan *CountItem, error) {
+	n, err := strconv.Atoi(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan *CountItem)
+	go func() {
+		defer close(ch)
+		for i := 1; i <= n; i++ {
+			ch <- &CountItem{i}
+		}
+	}()
+	return ch, nil
+}
+
+// MyUpload sums items of client, "slow" request delays consuming of every item
+func (p *MyProtocol) MyUpload(ctx context.Context, req *SomeReq, s RecvStream[*CountItem]) (*CountItem, error) {
+	sum := 0
+	for {
+		if req.Name == "slow" {
+			time.Sleep(10 * time.Millisecond)
+		}
+		item, err := s.Recv()
+		if err == io.EOF {
+			return &CountItem{sum}, nil
+		}
+		if err != nil {
+			if p.ctxErr != nil {
+				p.ctxErr <- err
+			}
+			return nil, err
+		}
+		sum += item.N
+	}
+}
+
+// MyEcho sends back doubled items of client
+func (p *MyProtocol) MyEcho(ctx context.Context, req *SomeReq, s BidiStream[*CountItem, *CountItem]) error {
+	for {
+		item, err := s.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			err = s.Send(&CountItem{item.N * 2})
+		}
+		if err != nil {
+			if p.ctxErr != nil {
+				p.ctxErr <- err
+			}
+			return err
+		}
+	}
+}
+
+// MyIdentity returns identity of authenticated client
+func (p *MyProtocol) MyIdentity(ctx context.Context, req *SomeReq) (*SomeReq, error) {
+	identity, _ := ConnFromContext(ctx).Identity().(string)
+	return &SomeReq{identity}, nil
+}
+
 func (p *MyProtocol) EmitInvalidNotification(req *SomeReq) (*SomeResp, error) {
 	err := p.conn.Notify(&SomeResp{})
 	return nil, err
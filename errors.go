@@ -0,0 +1,124 @@
+package wsrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+)
+
+// ErrorCode classifies errors returned by remote side.
+// Codes below 100 are reserved for wsrpc.
+type ErrorCode int
+
+// reserved error codes
+const (
+	ErrCodeUnknown          = ErrorCode(0) // error returned by protocol method
+	ErrCodeMethodNotFound   = ErrorCode(1)
+	ErrCodeInvalidRequest   = ErrorCode(2)
+	ErrCodeInternal         = ErrorCode(3)
+	ErrCodeOverloaded       = ErrorCode(4)
+	ErrCodePermissionDenied = ErrorCode(5)
+	ErrCodeWrongPhase       = ErrorCode(6)
+)
+
+// sentinel errors for matching with errors.Is
+var (
+	ErrMethodNotFound   = &RemoteError{Code: ErrCodeMethodNotFound, Message: "method not found"}
+	ErrInvalidRequest   = &RemoteError{Code: ErrCodeInvalidRequest, Message: "invalid request"}
+	ErrInternal         = &RemoteError{Code: ErrCodeInternal, Message: "internal error"}
+	ErrOverloaded       = &RemoteError{Code: ErrCodeOverloaded, Message: "server overloaded"}
+	ErrPermissionDenied = &RemoteError{Code: ErrCodePermissionDenied, Message: "permission denied"}
+	ErrWrongPhase       = &RemoteError{Code: ErrCodeWrongPhase, Message: "method isn't allowed in session phase"}
+)
+
+// CodedError is implemented by declared protocol errors with custom code
+type CodedError interface {
+	error
+	ErrorCode() ErrorCode
+}
+
+// RemoteError is an error envelope transferred in PT_ERROR packets.
+// Envelope is always JSON encoded, Data contains declared protocol error
+// encoded with session codec.
+type RemoteError struct {
+	Code    ErrorCode
+	Message string
+	Type    string `json:",omitempty"`
+	Data    []byte `json:",omitempty"`
+
+	err error // reconstructed declared error
+}
+
+func (e *RemoteError) Error() string {
+	return e.Message
+}
+
+// Unwrap returns declared protocol error if it is reconstructed
+func (e *RemoteError) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether target is RemoteError with the same code
+func (e *RemoteError) Is(target error) bool {
+	t, ok := target.(*RemoteError)
+	if !ok {
+		return false
+	}
+	return t.Code == e.Code
+}
+
+// newRemoteError wraps err into envelope, declared protocol errors
+// found in err chain are encoded into envelope data
+func newRemoteError(pd *protocolDetails, codec Codec, code ErrorCode, err error) *RemoteError {
+	if re, ok := err.(*RemoteError); ok {
+		return re
+	}
+	re := &RemoteError{Code: code, Message: err.Error()}
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		et := reflect.TypeOf(e)
+		if et.Kind() != reflect.Ptr {
+			continue
+		}
+		if dt, ok := pd.errors[et.Elem().Name()]; !ok || dt != et.Elem() {
+			continue
+		}
+		data, merr := codec.Marshal(e)
+		if merr != nil {
+			break
+		}
+		re.Type = et.Elem().Name()
+		re.Data = data
+		if ce, ok := e.(CodedError); ok {
+			re.Code = ce.ErrorCode()
+		}
+		break
+	}
+	return re
+}
+
+// parseRemoteError decodes error envelope and reconstructs declared error
+func parseRemoteError(pd *protocolDetails, codec Codec, body []byte) *RemoteError {
+	re := &RemoteError{}
+	if err := json.Unmarshal(body, re); err != nil {
+		// legacy peers send plain error message
+		return &RemoteError{Code: ErrCodeUnknown, Message: string(body)}
+	}
+	if re.Type == "" {
+		return re
+	}
+	dt, ok := pd.errors[re.Type]
+	if !ok {
+		return re
+	}
+	v := reflect.New(dt)
+	if err := codec.Unmarshal(re.Data, v.Interface()); err != nil {
+		return re
+	}
+	re.err = v.Interface().(error)
+	return re
+}
+
+func (e *RemoteError) dump() []byte {
+	buf, _ := json.Marshal(e)
+	return buf
+}
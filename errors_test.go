@@ -0,0 +1,54 @@
+package wsrpc
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRemoteErrorEnvelope(t *testing.T) {
+	pd, err := parseSessionProtocol(&MyProtocol{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := NewPacket(PT_REQUEST, "MyFind", nil)
+	p := req.Error(fmt.Errorf("plain error"))
+	if p.Header.Type != PT_ERROR || string(p.Header.MessageId) != string(req.Header.MessageId) {
+		t.Fatal("invalid error packet header")
+	}
+	re := parseRemoteError(pd, JSONCodec, p.Body)
+	if re.Code != ErrCodeUnknown || re.Message != "plain error" || re.Unwrap() != nil {
+		t.Fatalf("unexpected envelope %+v", re)
+	}
+
+	// legacy peers send plain text body
+	re = parseRemoteError(pd, JSONCodec, []byte("old style error"))
+	if re.Code != ErrCodeUnknown || re.Error() != "old style error" {
+		t.Fatalf("unexpected envelope %+v", re)
+	}
+
+	// declared errors are encoded with session codec
+	re = newRemoteError(pd, MsgpackCodec, ErrCodeUnknown, &NotFoundError{"Bob"})
+	if re.Type != "NotFoundError" || re.Message != "Bob not found" {
+		t.Fatalf("unexpected envelope %+v", re)
+	}
+	re = parseRemoteError(pd, MsgpackCodec, req.Error(re).Body)
+	var nf *NotFoundError
+	if !errors.As(re, &nf) || nf.Name != "Bob" {
+		t.Fatalf("NotFoundError expected, got %+v", re)
+	}
+
+	// undeclared error types are transferred as messages only
+	re = newRemoteError(pd, JSONCodec, ErrCodeUnknown, &ETest{5})
+	if re.Type != "" || re.Data != nil {
+		t.Fatalf("unexpected envelope %+v", re)
+	}
+
+	if !errors.Is(&RemoteError{Code: ErrCodeMethodNotFound, Message: "no method X found"}, ErrMethodNotFound) {
+		t.Fatal("errors.Is must match error codes")
+	}
+	if errors.Is(&RemoteError{Code: ErrCodeInternal}, fmt.Errorf("internal error")) {
+		t.Fatal("errors.Is must not match other errors")
+	}
+}
@@ -1,9 +1,10 @@
 package main
 
 import (
-	"../.."
-	"./protocol"
+	"github.com/fabregas/wsrpc"
+	"github.com/fabregas/wsrpc/examples/simple/protocol"
 
+	"context"
 	"fmt"
 	"time"
 
@@ -33,11 +34,11 @@ func main() {
 		panic(err)
 	}
 
-	resp, err := cli.Call("Sum", &protocol.SumReq{12, 44})
+	resp, err := wsrpc.Invoke[protocol.SumReq, protocol.SumResp](context.Background(), cli, "Sum", &protocol.SumReq{A: 12, B: 44})
 	if err != nil {
 		panic(err)
 	}
-	fmt.Println("12 + 44 =", resp.(*protocol.SumResp).Sum)
+	fmt.Println("12 + 44 =", resp.Sum)
 
 	cli.Close()
 }
@@ -1,7 +1,7 @@
 package protocol
 
 import (
-	"../../../"
+	"github.com/fabregas/wsrpc"
 
 	"fmt"
 )
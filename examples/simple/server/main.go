@@ -1,8 +1,8 @@
 package main
 
 import (
-	"../.."
-	"./protocol"
+	"github.com/fabregas/wsrpc"
+	"github.com/fabregas/wsrpc/examples/simple/protocol"
 
 	"fmt"
 	"os"
@@ -1,6 +1,8 @@
 package wsrpc
 
 import (
+	"container/heap"
+	"context"
 	"fmt"
 	"sync"
 	"time"
@@ -10,17 +12,21 @@ var (
 	TimeoutError = fmt.Errorf("waiter timeouted")
 )
 
+// shardsCount is number of independently locked waiters maps
+const shardsCount = 32
+
 type Waiter struct {
-	sync.RWMutex
+	done chan struct{}
 	data *Packet
 	err  error
 	ttl  time.Time
+
+	mid   string
+	index int // position in shard deadlines heap
 }
 
 func NewWaiter(ttl time.Time) *Waiter {
-	w := Waiter{ttl: ttl}
-	w.Lock() // lock waiter for write
-	return &w
+	return &Waiter{done: make(chan struct{}), ttl: ttl, index: -1}
 }
 
 func (w *Waiter) Timeouted() bool {
@@ -29,61 +35,205 @@ func (w *Waiter) Timeouted() bool {
 
 func (w *Waiter) setData(data *Packet) {
 	w.data = data
-	w.Unlock() // waiter ready for read
+	close(w.done) // waiter ready for read
 }
 
 func (w *Waiter) setError(err error) {
 	w.err = err
-	w.Unlock() // waiter ready for read
+	close(w.done) // waiter ready for read
 }
 
 func (w *Waiter) Wait() (*Packet, error) {
-	w.RLock()
-	defer w.RUnlock()
+	<-w.done
 	return w.data, w.err
 }
 
-type FlowController struct {
+// WaitContext waits for response or context cancellation
+func (w *Waiter) WaitContext(ctx context.Context) (*Packet, error) {
+	select {
+	case <-w.done:
+		return w.data, w.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// deadlinesHeap is min-heap of waiters ordered by ttl
+type deadlinesHeap []*Waiter
+
+func (h deadlinesHeap) Len() int           { return len(h) }
+func (h deadlinesHeap) Less(i, j int) bool { return h[i].ttl.Before(h[j].ttl) }
+func (h deadlinesHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *deadlinesHeap) Push(x interface{}) {
+	w := x.(*Waiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *deadlinesHeap) Pop() interface{} {
+	old := *h
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	w.index = -1
+	return w
+}
+
+// waitersShard holds part of waiters, shard timer fires at the earliest
+// waiter deadline, so there is no background work without waiters
+type waitersShard struct {
 	sync.Mutex
-	waiters map[string]*Waiter
+	waiters   map[string]*Waiter
+	deadlines deadlinesHeap
+	timer     *time.Timer
+	timerAt   time.Time // zero if timer is not armed
+	closed    bool
+}
+
+func (s *waitersShard) add(w *Waiter) {
+	if prev, ok := s.waiters[w.mid]; ok {
+		s.remove(prev)
+	}
+	s.waiters[w.mid] = w
+	heap.Push(&s.deadlines, w)
+	if s.timerAt.IsZero() || w.ttl.Before(s.timerAt) {
+		s.armTimer(w.ttl)
+	}
+}
+
+func (s *waitersShard) remove(w *Waiter) {
+	delete(s.waiters, w.mid)
+	if w.index >= 0 {
+		heap.Remove(&s.deadlines, w.index)
+	}
+}
+
+func (s *waitersShard) armTimer(at time.Time) {
+	s.timerAt = at
+	if s.timer == nil {
+		s.timer = time.AfterFunc(time.Until(at), s.expire)
+	} else {
+		s.timer.Reset(time.Until(at))
+	}
+}
+
+// expire fails timeouted waiters and rearms timer to the next deadline
+func (s *waitersShard) expire() {
+	var expired []*Waiter
+	now := time.Now()
+
+	s.Lock()
+	s.timerAt = time.Time{}
+	for len(s.deadlines) > 0 && !s.deadlines[0].ttl.After(now) {
+		w := heap.Pop(&s.deadlines).(*Waiter)
+		delete(s.waiters, w.mid)
+		expired = append(expired, w)
+	}
+	if len(s.deadlines) > 0 && !s.closed {
+		s.armTimer(s.deadlines[0].ttl)
+	}
+	s.Unlock()
+
+	for _, w := range expired {
+		w.setError(TimeoutError)
+	}
+}
+
+// FlowController matches responses with waiting calls. Waiters are spread
+// over independently locked shards to reduce contention on high call rates.
+type FlowController struct {
+	shards  [shardsCount]waitersShard
 	timeout time.Duration
 }
 
 func NewFlowController(timeout time.Duration) *FlowController {
-	fc := &FlowController{
-		waiters: make(map[string]*Waiter),
-		timeout: timeout,
+	fc := &FlowController{timeout: timeout}
+	for i := range fc.shards {
+		fc.shards[i].waiters = make(map[string]*Waiter)
 	}
-	go fc.checkTimeouts()
 	return fc
 }
 
-func (fc *FlowController) checkTimeouts() {
-	for _ = range time.Tick(fc.timeout / 3) {
-		fc.Lock()
-		for key, w := range fc.waiters {
-			if w.Timeouted() {
-				delete(fc.waiters, key)
-				w.setError(TimeoutError)
-			}
-		}
-		fc.Unlock()
+// shard returns shard of message id (FNV-1a hash)
+func (fc *FlowController) shard(mid string) *waitersShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(mid); i++ {
+		h ^= uint32(mid[i])
+		h *= 16777619
 	}
+	return &fc.shards[h%shardsCount]
 }
 
 func (fc *FlowController) NewWaiter(mid string) *Waiter {
-	ttl := time.Now().Add(fc.timeout)
-	rw := NewWaiter(ttl)
-	fc.Lock()
-	fc.waiters[mid] = rw
-	fc.Unlock()
+	return fc.NewWaiterWithDeadline(mid, time.Now().Add(fc.timeout))
+}
+
+// NewWaiterWithDeadline creates waiter which timeouts at given deadline
+// instead of default flow controller timeout
+func (fc *FlowController) NewWaiterWithDeadline(mid string, deadline time.Time) *Waiter {
+	rw := NewWaiter(deadline)
+	rw.mid = mid
+
+	s := fc.shard(mid)
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		rw.setError(ClosedConnError)
+		return rw
+	}
+	s.add(rw)
+	s.Unlock()
 	return rw
 }
 
+// GetWaiter removes waiter from flow controller and returns it,
+// nil is returned if there is no such waiter (e.g. it is already timeouted)
 func (fc *FlowController) GetWaiter(mid string) *Waiter {
-	fc.Lock()
-	rw := fc.waiters[mid]
-	delete(fc.waiters, mid)
-	fc.Unlock()
+	s := fc.shard(mid)
+	s.Lock()
+	rw := s.waiters[mid]
+	if rw != nil {
+		s.remove(rw)
+	}
+	s.Unlock()
 	return rw
 }
+
+// FailAll completes all pending waiters with given error
+func (fc *FlowController) FailAll(err error) {
+	fc.failAll(err, false)
+}
+
+// Close fails all pending waiters with ClosedConnError and stops timers,
+// waiters created after Close are failed immediately
+func (fc *FlowController) Close() {
+	fc.failAll(ClosedConnError, true)
+}
+
+func (fc *FlowController) failAll(err error, close bool) {
+	for i := range fc.shards {
+		s := &fc.shards[i]
+		s.Lock()
+		waiters := s.deadlines
+		s.waiters = make(map[string]*Waiter)
+		s.deadlines = nil
+		if close {
+			s.closed = true
+			if s.timer != nil {
+				s.timer.Stop()
+			}
+			s.timerAt = time.Time{}
+		}
+		s.Unlock()
+
+		for _, w := range waiters {
+			w.index = -1
+			w.setError(err)
+		}
+	}
+}
@@ -2,12 +2,78 @@ package wsrpc
 
 import (
 	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
+func TestWaitersTimeouts(t *testing.T) {
+	fc := NewFlowController(100 * time.Millisecond)
+	defer fc.Close()
+
+	started := time.Now()
+	w1 := fc.NewWaiter("w1")
+	w2 := fc.NewWaiterWithDeadline("w2", started.Add(30*time.Millisecond))
+	w3 := fc.NewWaiter("w3")
+
+	if _, err := w2.Wait(); err != TimeoutError {
+		t.Fatalf("timeout expected, got %v", err)
+	}
+	if d := time.Since(started); d < 30*time.Millisecond || d > 80*time.Millisecond {
+		t.Fatalf("deadline is not respected: %s", d)
+	}
+
+	if fc.GetWaiter("w3") != w3 {
+		t.Fatal("w3 waiter expected")
+	}
+	w3.setData(&Packet{})
+	if _, err := w1.Wait(); err != TimeoutError {
+		t.Fatalf("timeout expected, got %v", err)
+	}
+	if d := time.Since(started); d < 100*time.Millisecond || d > 150*time.Millisecond {
+		t.Fatalf("timeout is not respected: %s", d)
+	}
+	if p, err := w3.Wait(); p == nil || err != nil {
+		t.Fatalf("response expected, got %v, %v", p, err)
+	}
+	if fc.GetWaiter("w1") != nil || fc.GetWaiter("w2") != nil {
+		t.Fatal("timeouted waiters must be removed")
+	}
+}
+
+func TestWaitersClose(t *testing.T) {
+	fc := NewFlowController(time.Second)
+	w1 := fc.NewWaiter("w1")
+	w2 := fc.NewWaiter("w2")
+
+	fc.FailAll(TimeoutError)
+	if _, err := w1.Wait(); err != TimeoutError {
+		t.Fatalf("failed waiter expected, got %v", err)
+	}
+	w3 := fc.NewWaiter("w3")
+
+	fc.Close()
+	if _, err := w3.Wait(); err != ClosedConnError {
+		t.Fatalf("closed waiter expected, got %v", err)
+	}
+	if _, err := fc.NewWaiter("w4").Wait(); err != ClosedConnError {
+		t.Fatalf("waiter of closed flow controller must fail, got %v", err)
+	}
+	if _, err := w2.Wait(); err != TimeoutError {
+		t.Fatalf("failed waiter expected, got %v", err)
+	}
+	for i := range fc.shards {
+		if fc.shards[i].timer != nil && fc.shards[i].timer.Stop() {
+			t.Fatal("timers must be stopped on close")
+		}
+	}
+}
+
 func BenchmarkWaiters(b *testing.B) {
 	fc := NewFlowController(5 * time.Second)
+	defer fc.Close()
 	midCh := make(chan string, 1000)
 	waitersCh := make(chan *Waiter, 1000)
 	finishCh := make(chan int)
@@ -39,3 +105,64 @@ func BenchmarkWaiters(b *testing.B) {
 	close(midCh)
 	close(waitersCh)
 }
+
+// BenchmarkConcurrentCalls simulates 100k concurrent calls
+// which are responded by a few reader goroutines
+func BenchmarkConcurrentCalls(b *testing.B) {
+	const calls = 100000
+	fc := NewFlowController(5 * time.Second)
+	defer fc.Close()
+	mids := make([]string, calls)
+	for i := range mids {
+		mids[i] = fmt.Sprintf("test-%d", i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	var latency int64
+	for n := 0; n < b.N; n++ {
+		midCh := make(chan string, calls)
+		for r := 0; r < 4; r++ {
+			go func() {
+				for mid := range midCh {
+					if w := fc.GetWaiter(mid); w != nil {
+						w.setData(nil)
+					}
+				}
+			}()
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(calls)
+		for _, mid := range mids {
+			go func(mid string) {
+				started := time.Now()
+				w := fc.NewWaiter(mid)
+				midCh <- mid
+				w.Wait()
+				atomic.AddInt64(&latency, int64(time.Since(started)))
+				wg.Done()
+			}(mid)
+		}
+		wg.Wait()
+		close(midCh)
+	}
+	b.ReportMetric(float64(latency)/float64(b.N*calls), "ns/call")
+}
+
+func BenchmarkParallelWaiters(b *testing.B) {
+	fc := NewFlowController(5 * time.Second)
+	defer fc.Close()
+	var seq int64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mid := strconv.FormatInt(atomic.AddInt64(&seq, 1), 10)
+			w := fc.NewWaiter(mid)
+			fc.GetWaiter(mid).setData(nil)
+			w.Wait()
+		}
+	})
+}
@@ -0,0 +1,18 @@
+module github.com/fabregas/wsrpc
+
+go 1.23
+
+require (
+	github.com/fxamacker/cbor/v2 v2.9.0
+	github.com/gorilla/websocket v1.2.0
+	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
+	github.com/satori/go.uuid v1.2.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	google.golang.org/protobuf v1.36.9
+)
+
+require (
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+)
@@ -0,0 +1,71 @@
+package wsrpc
+
+import (
+	"context"
+)
+
+// ServerHandler calls protocol method with decoded request
+type ServerHandler func(ctx context.Context, req interface{}) (interface{}, error)
+
+// ServerInterceptor wraps protocol method calls on server side.
+// Interceptor can modify context, request and response or return error
+// without calling next handler.
+type ServerInterceptor func(ctx context.Context, conn *RPCConn, method string, req interface{}, next ServerHandler) (interface{}, error)
+
+// chainServerInterceptors combines interceptors into one,
+// the first interceptor is the outermost one
+func chainServerInterceptors(ics []ServerInterceptor) ServerInterceptor {
+	switch len(ics) {
+	case 0:
+		return nil
+	case 1:
+		return ics[0]
+	}
+	return func(ctx context.Context, conn *RPCConn, method string, req interface{}, handler ServerHandler) (interface{}, error) {
+		next := handler
+		for i := len(ics) - 1; i > 0; i-- {
+			ic, n := ics[i], next
+			next = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return ic(ctx, conn, method, req, n)
+			}
+		}
+		return ics[0](ctx, conn, method, req, next)
+	}
+}
+
+// ClientInvoker performs remote call or delivers notification to client handler
+type ClientInvoker func(ctx context.Context, method string, req interface{}) (interface{}, error)
+
+// ClientInterceptor wraps RPCClient calls and incoming notifications dispatch.
+// For notifications method is notification name, req is received notification
+// and invoker passes it to client notification handler.
+type ClientInterceptor func(ctx context.Context, method string, req interface{}, invoker ClientInvoker) (interface{}, error)
+
+type notificationCtxKey struct{}
+
+// IsNotificationContext reports whether client interceptor is called for incoming notification
+func IsNotificationContext(ctx context.Context) bool {
+	v, _ := ctx.Value(notificationCtxKey{}).(bool)
+	return v
+}
+
+// chainClientInterceptors combines interceptors into one,
+// the first interceptor is the outermost one
+func chainClientInterceptors(ics []ClientInterceptor) ClientInterceptor {
+	switch len(ics) {
+	case 0:
+		return nil
+	case 1:
+		return ics[0]
+	}
+	return func(ctx context.Context, method string, req interface{}, invoker ClientInvoker) (interface{}, error) {
+		next := invoker
+		for i := len(ics) - 1; i > 0; i-- {
+			ic, n := ics[i], next
+			next = func(ctx context.Context, method string, req interface{}) (interface{}, error) {
+				return ic(ctx, method, req, n)
+			}
+		}
+		return ics[0](ctx, method, req, next)
+	}
+}
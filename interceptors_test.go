@@ -0,0 +1,195 @@
+package wsrpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type ctxKey string
+
+func TestServerInterceptors(t *testing.T) {
+	var mu sync.Mutex
+	calls := []string{}
+	record := func(s string) {
+		mu.Lock()
+		calls = append(calls, s)
+		mu.Unlock()
+	}
+
+	logging := func(ctx context.Context, conn *RPCConn, method string, req interface{}, next ServerHandler) (interface{}, error) {
+		record("log:" + method)
+		resp, err := next(context.WithValue(ctx, ctxKey("user"), "bob"), req)
+		record("log:done")
+		return resp, err
+	}
+	auth := func(ctx context.Context, conn *RPCConn, method string, req interface{}, next ServerHandler) (interface{}, error) {
+		record("auth:" + ctx.Value(ctxKey("user")).(string))
+		if conn == nil {
+			t.Error("session expected in interceptor")
+		}
+		if req.(*SomeReq).Name == "Mallory" {
+			return nil, &DeniedError{"mallory is not allowed"}
+		}
+		return next(ctx, req)
+	}
+	mutate := func(ctx context.Context, conn *RPCConn, method string, req interface{}, next ServerHandler) (interface{}, error) {
+		record("mutate")
+		r := req.(*SomeReq)
+		r.Name = strings.ToUpper(r.Name[:1]) + r.Name[1:]
+		resp, err := next(ctx, req)
+		if err == nil && method == "MyFind" {
+			resp.(*SomeResp).IsBob = !resp.(*SomeResp).IsBob
+		}
+		return resp, err
+	}
+
+	conns := make(chan RPCTransport)
+	srv, err := NewRPCServer(
+		conns, func() SessionProtocol { return &MyProtocol{} }, &DummyLogger{},
+		WithServerInterceptors(logging, auth), WithServerInterceptors(mutate),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+
+	conn := NewFakeConn()
+	conns <- conn
+	<-conn.out // hello notification
+
+	// chain order and request mutation
+	conn.in <- NewPacket(PT_REQUEST, "MyMethod", []byte("{\"name\":\"bob\"}"))
+	p := <-conn.out
+	if p.Header.Type != PT_RESPONSE || string(p.Body) != "{\"IsBob\":true}" {
+		t.Fatalf("unexpected response %s", p)
+	}
+	if strings.Join(calls, ",") != "log:MyMethod,auth:bob,mutate,log:done" {
+		t.Fatalf("unexpected interceptors calls: %v", calls)
+	}
+
+	// response mutation
+	conn.in <- NewPacket(PT_REQUEST, "MyFind", []byte("{\"name\":\"bob\"}"))
+	p = <-conn.out
+	if p.Header.Type != PT_RESPONSE || string(p.Body) != "{\"IsBob\":false}" {
+		t.Fatalf("unexpected response %s", p)
+	}
+
+	// short circuit with declared error
+	calls = calls[:0]
+	conn.in <- NewPacket(PT_REQUEST, "MyMethod", []byte("{\"name\":\"Mallory\"}"))
+	p = <-conn.out
+	re := parseRemoteError(srv.protDetails, JSONCodec, p.Body)
+	if p.Header.Type != PT_ERROR || re.Code != 403 || re.Type != "DeniedError" {
+		t.Fatalf("unexpected response %s", p)
+	}
+	if strings.Join(calls, ",") != "log:MyMethod,auth:bob,log:done" {
+		t.Fatalf("unexpected interceptors calls: %v", calls)
+	}
+}
+
+func TestServerInterceptorInvalidRequest(t *testing.T) {
+	replace := func(ctx context.Context, conn *RPCConn, method string, req interface{}, next ServerHandler) (interface{}, error) {
+		return next(ctx, &SomeResp{})
+	}
+	conns := make(chan RPCTransport)
+	srv, err := NewRPCServer(conns, func() SessionProtocol { return &MyProtocol{} }, &DummyLogger{}, WithServerInterceptors(replace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+
+	conn := NewFakeConn()
+	conns <- conn
+	<-conn.out // hello notification
+
+	conn.simulateReq()
+	p := <-conn.out
+	re := parseRemoteError(srv.protDetails, JSONCodec, p.Body)
+	if p.Header.Type != PT_ERROR || re.Message != "invalid request type, *SomeReq expected" {
+		t.Fatalf("unexpected response %s", p)
+	}
+}
+
+func TestClientInterceptors(t *testing.T) {
+	closech := make(chan struct{})
+	go ServeWSRPC(func() SessionProtocol { return &MyProtocol{} }, ":8084", "/test/wsrpc", &DummyLogger{}, closech)
+	time.Sleep(100 * time.Millisecond)
+	defer close(closech)
+
+	var mu sync.Mutex
+	calls := []string{}
+	record := func(s string) {
+		mu.Lock()
+		calls = append(calls, s)
+		mu.Unlock()
+	}
+	recorded := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return strings.Join(calls, ",")
+	}
+
+	metrics := func(ctx context.Context, method string, req interface{}, invoker ClientInvoker) (interface{}, error) {
+		resp, err := invoker(ctx, method, req)
+		if IsNotificationContext(ctx) {
+			record("notif:" + method + ":" + req.(*MyNotif).Msg)
+		} else {
+			record("call:" + method)
+		}
+		return resp, err
+	}
+	retry := func(ctx context.Context, method string, req interface{}, invoker ClientInvoker) (interface{}, error) {
+		resp, err := invoker(ctx, method, req)
+		var nf *NotFoundError
+		if errors.As(err, &nf) {
+			record("retry")
+			return invoker(ctx, method, &SomeReq{"Bob"})
+		}
+		return resp, err
+	}
+	block := func(ctx context.Context, method string, req interface{}, invoker ClientInvoker) (interface{}, error) {
+		if method == "MySleep" {
+			return nil, errors.New("blocked by interceptor")
+		}
+		return invoker(ctx, method, req)
+	}
+
+	notifch := make(chan interface{}, 1)
+	onNotif := func(n interface{}, err error) {
+		notifch <- n
+	}
+	cli, err := ClientWSRPC(
+		&MyProtocol{}, "ws://127.0.0.1:8084/test/wsrpc", time.Second, onNotif, &DummyLogger{},
+		WithClientInterceptors(metrics, retry), WithClientInterceptors(block),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	if n := (<-notifch).(*MyNotif); n.Msg != "hello, dude!" {
+		t.Fatalf("unexpected notification %v", n)
+	}
+	if recorded() != "notif:MyNotif:hello, dude!" {
+		t.Fatalf("unexpected interceptors calls: %s", recorded())
+	}
+
+	resp, err := cli.Call("MyFind", &SomeReq{"Alice"})
+	if err != nil || !resp.(*SomeResp).IsBob {
+		t.Fatalf("unexpected result: %v, %v", resp, err)
+	}
+	if recorded() != "notif:MyNotif:hello, dude!,retry,call:MyFind" {
+		t.Fatalf("unexpected interceptors calls: %s", recorded())
+	}
+
+	_, err = cli.Call("MySleep", &SomeReq{"Alice"})
+	if err == nil || err.Error() != "blocked by interceptor" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package wsrpc
+
+import (
+	"net/http"
+	"net/netip"
+	"runtime"
+	"time"
+)
+
+// Option configures RPCServer, RPCClient and websocket transport
+type Option func(*options)
+
+type options struct {
+	codecs []Codec
+
+	// server workers pool settings
+	minWorkers        int
+	maxWorkers        int
+	queueSize         int
+	workerIdleTimeout time.Duration
+	maxStreams        int
+
+	// sequential processing of session requests
+	orderedSessions bool
+	orderedMethods  map[string]bool
+
+	panicHandler       PanicHandler
+	serverInterceptors []ServerInterceptor
+	clientInterceptors []ClientInterceptor
+
+	reconnect *ReconnectPolicy
+
+	// default timeout of server to client calls
+	callTimeout time.Duration
+
+	// number of stream items receiver can buffer
+	streamWindow int
+
+	// outbound queue of server session
+	outboundQueueSize int
+	slowConsumer      SlowConsumerPolicy
+
+	// websocket handshake
+	authenticator   Authenticator
+	handshakeHeader http.Header
+	trustedProxies  []netip.Prefix
+	methodRoles     MethodRoles
+	authorizer      Authorizer
+	topicAuthorizer TopicAuthorizer
+
+	sessionFactory func(info ConnInfo) SessionProtocol
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		codecs:            []Codec{JSONCodec},
+		minWorkers:        runtime.NumCPU(),
+		maxWorkers:        1000,
+		queueSize:         1000,
+		workerIdleTimeout: 30 * time.Second,
+		maxStreams:        1000,
+		orderedMethods:    make(map[string]bool),
+		callTimeout:       30 * time.Second,
+		streamWindow:      16,
+		outboundQueueSize: 1024,
+		slowConsumer:      BlockSender,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithCodecs sets supported body codecs in order of preference
+func WithCodecs(codecs ...Codec) Option {
+	return func(o *options) {
+		if len(codecs) > 0 {
+			o.codecs = codecs
+		}
+	}
+}
+
+// WithWorkers sets minimal and maximal number of server workers,
+// streaming calls are served out of workers pool (see WithMaxStreams)
+func WithWorkers(min, max int) Option {
+	return func(o *options) {
+		if max < 1 {
+			max = 1
+		}
+		if min < 0 {
+			min = 0
+		}
+		if min > max {
+			min = max
+		}
+		o.minWorkers, o.maxWorkers = min, max
+	}
+}
+
+// WithMaxStreams sets maximal number of streaming calls served concurrently
+// out of workers pool, streams exceeding the limit are rejected with ErrOverloaded error
+func WithMaxStreams(max int) Option {
+	return func(o *options) {
+		if max < 1 {
+			max = 1
+		}
+		o.maxStreams = max
+	}
+}
+
+// WithQueueSize sets maximal number of requests waiting for free worker,
+// requests exceeding the limit are rejected with ErrOverloaded error
+func WithQueueSize(size int) Option {
+	return func(o *options) {
+		if size < 1 {
+			size = 1
+		}
+		o.queueSize = size
+	}
+}
+
+// WithWorkerIdleTimeout sets duration after which idle workers
+// above minimal number are stopped
+func WithWorkerIdleTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		if timeout > 0 {
+			o.workerIdleTimeout = timeout
+		}
+	}
+}
+
+// WithOrderedSessions makes server process requests of each session strictly
+// sequentially in order of receiving, different sessions are still processed in parallel
+func WithOrderedSessions() Option {
+	return func(o *options) {
+		o.orderedSessions = true
+	}
+}
+
+// WithOrderedMethods makes server process calls of given methods sequentially
+// within session, other methods are processed concurrently
+func WithOrderedMethods(methods ...string) Option {
+	return func(o *options) {
+		for _, m := range methods {
+			o.orderedMethods[m] = true
+		}
+	}
+}
+
+// WithPanicHandler sets hook called when protocol method panics
+func WithPanicHandler(h PanicHandler) Option {
+	return func(o *options) {
+		o.panicHandler = h
+	}
+}
+
+// WithServerInterceptors appends interceptors of protocol method calls on server side,
+// the first interceptor is the outermost one
+func WithServerInterceptors(interceptors ...ServerInterceptor) Option {
+	return func(o *options) {
+		o.serverInterceptors = append(o.serverInterceptors, interceptors...)
+	}
+}
+
+// WithClientInterceptors appends interceptors of RPCClient calls and notifications,
+// the first interceptor is the outermost one
+func WithClientInterceptors(interceptors ...ClientInterceptor) Option {
+	return func(o *options) {
+		o.clientInterceptors = append(o.clientInterceptors, interceptors...)
+	}
+}
+
+// WithCallTimeout sets default timeout of RPCConn.Call requests to client,
+// context deadline overrides it
+func WithCallTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		if timeout > 0 {
+			o.callTimeout = timeout
+		}
+	}
+}
+
+// WithStreamWindow sets number of stream items peer can send
+// before they are consumed by receiving side
+func WithStreamWindow(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.streamWindow = n
+		}
+	}
+}
+
+// WithOutboundQueue sets size of outbound packets queue of server session
+// (1024 by default) and policy applied to notifications when the queue is
+// full (BlockSender by default, Broadcast and Publish don't block and
+// reject notification instead). Notifications sent in OnConnect stay in
+// the queue until OnConnect returns.
+func WithOutboundQueue(size int, policy SlowConsumerPolicy) Option {
+	return func(o *options) {
+		if size < 1 {
+			size = 1
+		}
+		o.outboundQueueSize = size
+		o.slowConsumer = policy
+	}
+}
@@ -0,0 +1,139 @@
+package wsrpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// SlowConsumerPolicy defines what happens with notification
+// when outbound queue of session is full
+type SlowConsumerPolicy int
+
+const (
+	// BlockSender makes Notify to wait for free room in queue,
+	// Broadcast and Publish reject notification with QueueFullError
+	BlockSender SlowConsumerPolicy = iota
+	// DropOldest drops the oldest queued notification to make room for new one
+	DropOldest
+	// DropNewest rejects new notification with QueueFullError
+	DropNewest
+	// DisconnectSlowConsumer closes session and rejects notification with SlowConsumerError
+	DisconnectSlowConsumer
+)
+
+var (
+	QueueFullError    = fmt.Errorf("outbound queue is full")
+	SlowConsumerError = fmt.Errorf("session is closed as slow consumer")
+)
+
+// outboundQueue is bounded FIFO of packets waiting for session sender.
+// Only notifications are dropped by policy, other packets always wait
+// for free room.
+type outboundQueue struct {
+	mu      sync.Mutex
+	packets []*Packet
+	size    int
+	policy  SlowConsumerPolicy
+
+	ready   chan struct{} // signals queued packet
+	hasRoom chan struct{} // signals popped packet
+}
+
+func newOutboundQueue(size int, policy SlowConsumerPolicy) *outboundQueue {
+	return &outboundQueue{
+		packets: make([]*Packet, 0, size),
+		size:    size,
+		policy:  policy,
+		ready:   make(chan struct{}, 1),
+		hasRoom: make(chan struct{}, 1),
+	}
+}
+
+func droppable(p *Packet) bool {
+	return p.Header.Type == PT_NOTIFICATION || p.Header.Type == PT_PUBLISH
+}
+
+// push queues packet, ctx is done when session is closed
+func (q *outboundQueue) push(ctx context.Context, p *Packet) error {
+	return q.enqueue(ctx, p, true)
+}
+
+// offer queues notification without waiting for free room,
+// it is rejected with QueueFullError instead of blocking
+func (q *outboundQueue) offer(ctx context.Context, p *Packet) error {
+	return q.enqueue(ctx, p, false)
+}
+
+func (q *outboundQueue) enqueue(ctx context.Context, p *Packet, wait bool) error {
+	if ctx.Err() != nil {
+		return ClosedConnError
+	}
+	for {
+		q.mu.Lock()
+		if len(q.packets) < q.size {
+			q.packets = append(q.packets, p)
+			q.mu.Unlock()
+			signal(q.ready)
+			return nil
+		}
+
+		policy := q.policy
+		if !droppable(p) {
+			policy = BlockSender
+		}
+		switch policy {
+		case DropOldest:
+			for i, old := range q.packets {
+				if droppable(old) {
+					copy(q.packets[i:], q.packets[i+1:])
+					q.packets[len(q.packets)-1] = p
+					q.mu.Unlock()
+					return nil
+				}
+			}
+			// nothing to drop
+			q.mu.Unlock()
+			return QueueFullError
+		case DropNewest:
+			q.mu.Unlock()
+			return QueueFullError
+		case DisconnectSlowConsumer:
+			q.mu.Unlock()
+			return SlowConsumerError
+		}
+		q.mu.Unlock()
+		if !wait && droppable(p) {
+			return QueueFullError
+		}
+
+		select {
+		case <-q.hasRoom:
+		case <-ctx.Done():
+			return ClosedConnError
+		}
+	}
+}
+
+// pop returns the first queued packet, nil is returned if queue is empty
+func (q *outboundQueue) pop() *Packet {
+	q.mu.Lock()
+	if len(q.packets) == 0 {
+		q.mu.Unlock()
+		return nil
+	}
+	p := q.packets[0]
+	copy(q.packets, q.packets[1:])
+	q.packets[len(q.packets)-1] = nil
+	q.packets = q.packets[:len(q.packets)-1]
+	q.mu.Unlock()
+	signal(q.hasRoom)
+	return p
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,254 @@
+package wsrpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func queuedMethods(q *outboundQueue) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ret := []string{}
+	for _, p := range q.packets {
+		ret = append(ret, p.Header.Method)
+	}
+	return ret
+}
+
+func expectQueued(t *testing.T, q *outboundQueue, methods ...string) {
+	t.Helper()
+	queued := queuedMethods(q)
+	if len(queued) != len(methods) {
+		t.Fatalf("%v expected in queue, got %v", methods, queued)
+	}
+	for i := range methods {
+		if queued[i] != methods[i] {
+			t.Fatalf("%v expected in queue, got %v", methods, queued)
+		}
+	}
+}
+
+func TestOutboundQueuePolicies(t *testing.T) {
+	ctx := context.Background()
+	notif := func(name string) *Packet { return NewPacket(PT_NOTIFICATION, name, []byte("{}")) }
+	resp := func(name string) *Packet { return NewPacket(PT_RESPONSE, name, []byte("{}")) }
+
+	// new notification is rejected
+	q := newOutboundQueue(2, DropNewest)
+	q.push(ctx, notif("n1"))
+	q.push(ctx, notif("n2"))
+	if err := q.push(ctx, notif("n3")); err != QueueFullError {
+		t.Fatalf("queue full error expected, got %v", err)
+	}
+	expectQueued(t, q, "n1", "n2")
+
+	// the oldest notification is dropped, responses are kept
+	q = newOutboundQueue(3, DropOldest)
+	q.push(ctx, resp("r1"))
+	q.push(ctx, notif("n1"))
+	q.push(ctx, notif("n2"))
+	if err := q.push(ctx, notif("n3")); err != nil {
+		t.Fatal(err)
+	}
+	expectQueued(t, q, "r1", "n2", "n3")
+
+	q = newOutboundQueue(1, DropOldest)
+	q.push(ctx, resp("r1"))
+	if err := q.push(ctx, notif("n1")); err != QueueFullError {
+		t.Fatalf("queue full error expected, got %v", err)
+	}
+
+	q = newOutboundQueue(1, DisconnectSlowConsumer)
+	q.push(ctx, notif("n1"))
+	if err := q.push(ctx, notif("n2")); err != SlowConsumerError {
+		t.Fatalf("slow consumer error expected, got %v", err)
+	}
+
+	// sender waits for free room, order is preserved
+	for _, policy := range []SlowConsumerPolicy{BlockSender, DropNewest} {
+		q = newOutboundQueue(2, policy)
+		q.push(ctx, resp("r1"))
+		q.push(ctx, resp("r2"))
+		pushed := make(chan error)
+		go func() { pushed <- q.push(ctx, resp("r3")) }()
+		select {
+		case err := <-pushed:
+			t.Fatalf("push must wait for free room, got %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if p := q.pop(); p.Header.Method != "r1" {
+			t.Fatalf("r1 expected, got %s", p)
+		}
+		if err := <-pushed; err != nil {
+			t.Fatal(err)
+		}
+		expectQueued(t, q, "r2", "r3")
+	}
+
+	// waiting is finished when session is closed
+	q = newOutboundQueue(1, BlockSender)
+	q.push(ctx, notif("n1"))
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := q.push(cctx, notif("n2")); err != ClosedConnError {
+		t.Fatalf("closed connection error expected, got %v", err)
+	}
+}
+
+func TestSlowConsumerDisconnect(t *testing.T) {
+	sessions := make(chan *MyProtocol, 1)
+	closed := make(chan bool, 1)
+	conns := make(chan RPCTransport)
+	srv, err := NewRPCServer(conns, func() SessionProtocol {
+		p := &MyProtocol{closed: closed}
+		sessions <- p
+		return p
+	}, &DummyLogger{}, WithOutboundQueue(2, DisconnectSlowConsumer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-sessions // protocol instance of NewRPCServer
+	go srv.Run()
+	defer srv.Close()
+
+	conn := NewFakeConn()
+	conns <- conn
+	<-conn.out // hello notification, client doesn't read others
+	sess := (<-sessions).conn
+
+	for i := 0; i < 10 && err == nil; i++ {
+		err = sess.Notify(&MyNotif{"flood"})
+	}
+	if err != SlowConsumerError {
+		t.Fatalf("slow consumer error expected, got %v", err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("slow consumer is not disconnected")
+	}
+
+	err = srv.Broadcast(&MyNotif{"after disconnect"})
+	if err != nil {
+		t.Fatalf("no sessions expected, got %v", err)
+	}
+	if !errors.Is(sess.Notify(&MyNotif{"closed"}), ClosedConnError) {
+		t.Fatal("closed connection error expected")
+	}
+}
+
+func TestBroadcastStalledSession(t *testing.T) {
+	conns := make(chan RPCTransport)
+	srv, err := NewRPCServer(conns, func() SessionProtocol { return &MyProtocol{} }, &DummyLogger{},
+		WithOutboundQueue(1, BlockSender))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+
+	stalled, healthy := NewFakeConn(), []*FakeConn{NewFakeConn(), NewFakeConn()}
+	for _, fc := range append(healthy, stalled) {
+		conns <- fc
+		<-fc.out // hello notification, stalled client doesn't read others
+	}
+
+	var rejected error
+	for i := 0; i < 5; i++ {
+		done := make(chan error, 1)
+		go func() { done <- srv.Broadcast(&MyNotif{"to all"}) }()
+		select {
+		case err := <-done:
+			// stalled sender takes one more packet now and then
+			if rejected == nil {
+				rejected = err
+			}
+		case <-time.After(time.Second):
+			t.Fatal("broadcast is blocked by stalled session")
+		}
+		for _, fc := range healthy {
+			if p := <-fc.out; p.Header.Type != PT_NOTIFICATION {
+				t.Fatalf("notification expected, got %s", p)
+			}
+		}
+	}
+	if !errors.Is(rejected, QueueFullError) {
+		t.Fatalf("queue full error expected, got %v", rejected)
+	}
+	if err := srv.Publish("news", &MyNotif{"nobody"}); err != nil {
+		t.Fatalf("no subscribers expected, got %v", err)
+	}
+}
+
+// stalledConn is transport which write is stuck until writes are released,
+// Close waits for the write like WsTransport does
+type stalledConn struct {
+	*FakeConn
+	wlock   sync.Mutex
+	release chan struct{}
+}
+
+func (c *stalledConn) Send(p *Packet) error {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	<-c.release
+	return nil
+}
+
+func (c *stalledConn) Close() error {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	return c.FakeConn.Close()
+}
+
+func TestBroadcastDisconnectsStalledSession(t *testing.T) {
+	closed := make(chan bool, 1)
+	conns := make(chan RPCTransport)
+	srv, err := NewRPCServer(conns, func() SessionProtocol { return &MyProtocol{closed: closed} }, &DummyLogger{},
+		WithOutboundQueue(1, DisconnectSlowConsumer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+
+	healthy := NewFakeConn()
+	conns <- healthy
+	<-healthy.out // hello notification
+	stalled := &stalledConn{FakeConn: NewFakeConn(), release: make(chan struct{})}
+	conns <- stalled // hello notification is stuck in write
+	for len(srv.Sessions()) != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var rejected error
+	for i := 0; i < 3; i++ {
+		done := make(chan error, 1)
+		go func() { done <- srv.Broadcast(&MyNotif{"to all"}) }()
+		select {
+		case err := <-done:
+			if rejected == nil {
+				rejected = err
+			}
+		case <-time.After(time.Second):
+			t.Fatal("broadcast is blocked by closing of stalled session")
+		}
+		if p := <-healthy.out; p.Header.Type != PT_NOTIFICATION {
+			t.Fatalf("notification expected, got %s", p)
+		}
+	}
+	if !errors.Is(rejected, SlowConsumerError) {
+		t.Fatalf("slow consumer error expected, got %v", rejected)
+	}
+
+	// stalled session is closed once its write is released
+	close(stalled.release)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("slow consumer is not disconnected")
+	}
+}
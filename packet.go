@@ -11,9 +11,17 @@ const (
 	PT_REQUEST      = uint8(1)
 	PT_RESPONSE     = uint8(2)
 	PT_NOTIFICATION = uint8(3)
+	PT_CANCEL       = uint8(4)
+	PT_STREAM_ITEM  = uint8(5)
+	PT_STREAM_END   = uint8(6)
+	PT_STREAM_ACK   = uint8(7) // grants credits to stream sender
+	PT_PUBLISH      = uint8(8) // notification published to topic
 	PT_ERROR        = uint8(66)
 )
 
+// maxMethodLen limits packet method, its length is stored in one byte
+const maxMethodLen = 255
+
 func printableType(t uint8) string {
 	switch t {
 	case PT_REQUEST:
@@ -22,6 +30,16 @@ func printableType(t uint8) string {
 		return "RESP"
 	case PT_NOTIFICATION:
 		return "NOTIF"
+	case PT_CANCEL:
+		return "CANCEL"
+	case PT_STREAM_ITEM:
+		return "ITEM"
+	case PT_STREAM_END:
+		return "END"
+	case PT_STREAM_ACK:
+		return "ACK"
+	case PT_PUBLISH:
+		return "PUB"
 	case PT_ERROR:
 		return "ERR"
 	default:
@@ -67,8 +85,24 @@ func (p *Packet) String() string {
 
 // Error returns error packet as a response on current packet
 func (p *Packet) Error(err error) *Packet {
+	re, ok := err.(*RemoteError)
+	if !ok {
+		re = &RemoteError{Code: ErrCodeUnknown, Message: err.Error()}
+	}
 	h := Header{MessageId: p.Header.MessageId, Type: PT_ERROR}
-	return &Packet{Header: h, Body: []byte(err.Error())}
+	return &Packet{Header: h, Body: re.dump()}
+}
+
+// Cancel returns packet which cancels current request with given reason
+func (p *Packet) Cancel(reason error) *Packet {
+	h := Header{MessageId: p.Header.MessageId, Type: PT_CANCEL, Method: p.Header.Method}
+	return &Packet{Header: h, Body: []byte(reason.Error())}
+}
+
+// reply creates packet of given type related to request p
+func (p *Packet) reply(ptype uint8, body []byte) *Packet {
+	h := Header{MessageId: p.Header.MessageId, Type: ptype, Method: p.Header.Method}
+	return &Packet{Header: h, Body: body}
 }
 
 func (p *Packet) Dump() []byte {
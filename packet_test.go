@@ -1,6 +1,7 @@
 package wsrpc
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 )
@@ -60,6 +61,20 @@ func TestPacket(t *testing.T) {
 	NewPacket(PT_RESPONSE, "-", []byte("-")).String()
 	NewPacket(PT_NOTIFICATION, "-", []byte("-")).String()
 	NewPacket(PT_ERROR, "-", []byte("-")).String()
+	if printableType(PT_CANCEL) != "CANCEL" {
+		t.Error("invalid cancel type repr")
+	}
+
+	cp, err := ParsePacket(p.Cancel(fmt.Errorf("timeout")).Dump())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Header.Type != PT_CANCEL || cp.Id() != p.Id() || cp.Header.Method != p.Header.Method {
+		t.Errorf("invalid cancel packet %s", cp)
+	}
+	if string(cp.Body) != "timeout" {
+		t.Errorf("invalid cancel reason %s", cp.Body)
+	}
 	NewPacket(88, "-", []byte("-")).String()
 
 	//parse packet fails scenarios
@@ -0,0 +1,79 @@
+package wsrpc
+
+import (
+	"fmt"
+)
+
+// Phase is a named state of session, e.g. "hello", "login", "authenticated"
+type Phase string
+
+// SessionPhases declares phases of session protocol
+type SessionPhases struct {
+	Initial Phase              // phase of new session
+	Methods map[Phase][]string // methods callable in phase
+}
+
+// PhasedSession is implemented by session protocols which methods are
+// callable only in some phases of session. Methods which aren't listed in
+// any phase are callable in every phase, built-in SubscribeMethod and
+// UnsubscribeMethod may be listed too. Client notifications are listed under
+// their names, they are dropped out of phase. Handlers move session to next
+// phase with RPCConn.SetPhase.
+type PhasedSession interface {
+	Phases() SessionPhases
+}
+
+// parsePhases returns phases allowing every restricted method of protocol p
+func parsePhases(p SessionProtocol, pd *protocolDetails) (map[string]map[Phase]struct{}, error) {
+	ps, ok := p.(PhasedSession)
+	if !ok {
+		return nil, nil
+	}
+	ret := make(map[string]map[Phase]struct{})
+	for phase, methods := range ps.Phases().Methods {
+		for _, m := range methods {
+			_, isNotif := pd.clientNotifications[m]
+			if _, ok := pd.methods[m]; !ok && !isNotif && m != SubscribeMethod && m != UnsubscribeMethod {
+				return nil, fmt.Errorf("unknown method %s in phase %s", m, phase)
+			}
+			if ret[m] == nil {
+				ret[m] = make(map[Phase]struct{})
+			}
+			ret[m][phase] = struct{}{}
+		}
+	}
+	return ret, nil
+}
+
+// Phase returns current phase of session
+func (c *RPCConn) Phase() Phase {
+	c.phaseLock.Lock()
+	defer c.phaseLock.Unlock()
+	return c.phase
+}
+
+// SetPhase moves session to given phase. Phase of protocol method call is
+// checked when worker starts it, so concurrent calls received before transition
+// may be checked against new phase, WithOrderedSessions makes every call see
+// phase set by previous ones. Subscriptions are checked on receiving.
+func (c *RPCConn) SetPhase(phase Phase) {
+	c.phaseLock.Lock()
+	c.phase = phase
+	c.phaseLock.Unlock()
+}
+
+// checkPhase returns error if method isn't callable in current phase of session
+func (c *RPCConn) checkPhase(method string) error {
+	phases, ok := c.protDetails.phases[method]
+	if !ok {
+		return nil
+	}
+	phase := c.Phase()
+	if _, ok := phases[phase]; !ok {
+		return &RemoteError{
+			Code:    ErrCodeWrongPhase,
+			Message: fmt.Sprintf("method %s isn't allowed in phase %s", method, phase),
+		}
+	}
+	return nil
+}
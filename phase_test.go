@@ -0,0 +1,153 @@
+package wsrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type PhasedProtocol struct {
+	phases chan Phase
+	typing chan string
+
+	Notifications struct {
+		*MyNotif
+	}
+	ClientNotifications struct {
+		*TypingNotif
+	}
+}
+
+func (p *PhasedProtocol) Phases() SessionPhases {
+	return SessionPhases{
+		Initial: "hello",
+		Methods: map[Phase][]string{
+			"hello":         {"Login"},
+			"authenticated": {"Balance", "Logout", "TypingNotif", SubscribeMethod},
+		},
+	}
+}
+
+func (p *PhasedProtocol) OnConnect(conn *RPCConn) {
+	p.phases <- conn.Phase()
+}
+
+func (p *PhasedProtocol) OnDisconnect(err error) {}
+
+func (p *PhasedProtocol) OnTypingNotif(n *TypingNotif) {
+	p.typing <- n.User
+}
+
+func (p *PhasedProtocol) Login(ctx context.Context, req *SomeReq) (*SomeResp, error) {
+	if req.Name != "Bob" {
+		return nil, errors.New("unknown user")
+	}
+	ConnFromContext(ctx).SetPhase("authenticated")
+	return &SomeResp{IsBob: true}, nil
+}
+
+func (p *PhasedProtocol) Logout(ctx context.Context, req *SomeReq) (*SomeResp, error) {
+	ConnFromContext(ctx).SetPhase("hello")
+	return &SomeResp{}, nil
+}
+
+func (p *PhasedProtocol) Balance(req *SomeReq) (*SomeResp, error) {
+	return &SomeResp{IsBob: true}, nil
+}
+
+// Ping is callable in every phase
+func (p *PhasedProtocol) Ping(req *SomeReq) (*SomeResp, error) {
+	return &SomeResp{}, nil
+}
+
+type BadPhasedProtocol struct {
+	PhasedProtocol
+}
+
+func (p *BadPhasedProtocol) Phases() SessionPhases {
+	return SessionPhases{Methods: map[Phase][]string{"hello": {"Unknown"}}}
+}
+
+func TestSessionPhases(t *testing.T) {
+	_, err := NewRPCServer(nil, func() SessionProtocol { return &BadPhasedProtocol{} }, &DummyLogger{})
+	if err == nil || err.Error() != "unknown method Unknown in phase hello" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	phases := make(chan Phase, 2)
+	typing := make(chan string, 2)
+	conns := make(chan RPCTransport)
+	srv, err := NewRPCServer(conns, func() SessionProtocol { return &PhasedProtocol{phases: phases, typing: typing} }, &DummyLogger{},
+		WithOrderedSessions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.protDetails.methods["Phases"]; ok {
+		t.Fatal("Phases must not be protocol method")
+	}
+	go srv.Run()
+	defer srv.Close()
+
+	conn := NewFakeConn()
+	conns <- conn
+	if phase := <-phases; phase != "hello" {
+		t.Fatalf("initial phase hello expected, got %s", phase)
+	}
+
+	for _, tc := range []struct {
+		method string
+		name   string
+		err    error
+	}{
+		{"Balance", "Bob", ErrWrongPhase},
+		{"Logout", "Bob", ErrWrongPhase},
+		{"Ping", "Bob", nil},
+		{"Login", "Alice", &RemoteError{Code: ErrCodeUnknown}},
+		{"Balance", "Bob", ErrWrongPhase},
+		{"Login", "Bob", nil},
+		{"Login", "Bob", ErrWrongPhase},
+		{"Balance", "Bob", nil},
+		{"Ping", "Bob", nil},
+		{"Logout", "Bob", nil},
+		{"Balance", "Bob", ErrWrongPhase},
+	} {
+		conn.in <- NewPacket(PT_REQUEST, tc.method, []byte(`{"Name":"`+tc.name+`"}`))
+		p := <-conn.out
+		if tc.err == nil {
+			if p.Header.Type != PT_RESPONSE {
+				t.Fatalf("%s(%s): response expected, got %s", tc.method, tc.name, p)
+			}
+			continue
+		}
+		if p.Header.Type != PT_ERROR {
+			t.Fatalf("%s(%s): error expected, got %s", tc.method, tc.name, p)
+		}
+		if err := parseRemoteError(srv.protDetails, JSONCodec, p.Body); !errors.Is(err, tc.err) {
+			t.Fatalf("%s(%s): %v expected, got %v", tc.method, tc.name, tc.err, err)
+		}
+	}
+
+	// built-in subscription is gated too
+	conn.in <- NewPacket(PT_REQUEST, SubscribeMethod, []byte("news"))
+	p := <-conn.out
+	if err := parseRemoteError(srv.protDetails, JSONCodec, p.Body); p.Header.Type != PT_ERROR || !errors.Is(err, ErrWrongPhase) {
+		t.Fatalf("wrong phase expected, got %s", p)
+	}
+	conn.in <- NewPacket(PT_REQUEST, "Login", []byte(`{"Name":"Bob"}`))
+	<-conn.out
+	conn.in <- NewPacket(PT_REQUEST, SubscribeMethod, []byte("news"))
+	if p := <-conn.out; p.Header.Type != PT_RESPONSE || srv.Subscribers("news") != 1 {
+		t.Fatalf("subscription expected, got %s", p)
+	}
+
+	// client notifications out of phase are dropped
+	conn.in <- NewPacket(PT_REQUEST, "Logout", []byte(`{"Name":"Bob"}`))
+	<-conn.out
+	conn.in <- NewPacket(PT_NOTIFICATION, "TypingNotif", []byte(`{"User":"early"}`))
+	conn.in <- NewPacket(PT_REQUEST, "Login", []byte(`{"Name":"Bob"}`))
+	<-conn.out
+	conn.in <- NewPacket(PT_NOTIFICATION, "TypingNotif", []byte(`{"User":"Bob"}`))
+	if user := <-typing; user != "Bob" {
+		t.Fatalf("notification of authenticated session expected, got %s", user)
+	}
+}
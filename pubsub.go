@@ -0,0 +1,259 @@
+package wsrpc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// built-in methods of topic subscriptions, request body is topic name.
+// They may be listed in methods of SessionPhases.
+const (
+	SubscribeMethod   = "wsrpc.Subscribe"
+	UnsubscribeMethod = "wsrpc.Unsubscribe"
+)
+
+// maxTopicLen limits topic name, it is passed in packet method with notification name
+const maxTopicLen = 128
+
+func checkTopic(topic string) error {
+	if topic == "" {
+		return fmt.Errorf("empty topic")
+	}
+	if len(topic) > maxTopicLen {
+		return fmt.Errorf("topic is longer than %d bytes", maxTopicLen)
+	}
+	return nil
+}
+
+// checkPublishTopic returns error if method of notification published
+// to topic may exceed maxMethodLen, so subscription would never get it
+func checkPublishTopic(pd *protocolDetails, topic string) error {
+	longest := ""
+	for name := range pd.notifications {
+		if len(name) > len(longest) || len(name) == len(longest) && name < longest {
+			longest = name
+		}
+	}
+	if len(publishMethod(longest, topic)) > maxMethodLen {
+		return fmt.Errorf("topic is too long for notification %s", longest)
+	}
+	return nil
+}
+
+// publishMethod returns method of published packet, it is <notification>:<topic>
+func publishMethod(name, topic string) string {
+	return name + ":" + topic
+}
+
+// parsePublishMethod returns notification name and topic of published packet
+func parsePublishMethod(method string) (name, topic string, ok bool) {
+	return strings.Cut(method, ":")
+}
+
+// Publish sends notification to sessions subscribed to topic,
+// notification is encoded only once per codec of subscribers
+func (rpc *RPCServer) Publish(topic string, notification interface{}) error {
+	if err := checkTopic(topic); err != nil {
+		return err
+	}
+	nt := reflect.TypeOf(notification)
+	if nt != nil && nt.Kind() == reflect.Ptr {
+		nt = nt.Elem()
+	}
+	if nt != nil && len(publishMethod(nt.Name(), topic)) > maxMethodLen {
+		return fmt.Errorf("topic is too long for notification %s", nt.Name())
+	}
+	rpc.topicsLock.RLock()
+	conns := make([]*RPCConn, 0, len(rpc.topics[topic]))
+	for conn := range rpc.topics[topic] {
+		conns = append(conns, conn)
+	}
+	rpc.topicsLock.RUnlock()
+
+	return notifySessions(conns, func(codec Codec) (*Packet, error) {
+		p, err := newNotificationPacket(rpc.protDetails, codec, notification)
+		if err != nil {
+			return nil, err
+		}
+		p.Header.Type = PT_PUBLISH
+		p.Header.Method = publishMethod(p.Header.Method, topic)
+		return p, nil
+	})
+}
+
+// Subscribers returns number of sessions subscribed to topic
+func (rpc *RPCServer) Subscribers(topic string) int {
+	rpc.topicsLock.RLock()
+	defer rpc.topicsLock.RUnlock()
+	return len(rpc.topics[topic])
+}
+
+// serveBuiltin processes requests of built-in methods,
+// false is returned for methods of session protocol
+func (rpc *RPCServer) serveBuiltin(conn *RPCConn, packet *Packet) bool {
+	method := packet.Header.Method
+	if method != SubscribeMethod && method != UnsubscribeMethod {
+		return false
+	}
+
+	if err := conn.checkPhase(method); err != nil {
+		conn.send(packet.Error(err))
+		return true
+	}
+	topic := string(packet.Body)
+	if err := checkTopic(topic); err != nil {
+		conn.send(packet.Error(&RemoteError{Code: ErrCodeInvalidRequest, Message: err.Error()}))
+		return true
+	}
+	if method == SubscribeMethod {
+		if err := checkPublishTopic(rpc.protDetails, topic); err != nil {
+			conn.send(packet.Error(&RemoteError{Code: ErrCodeInvalidRequest, Message: err.Error()}))
+			return true
+		}
+	}
+	if method == SubscribeMethod && rpc.topicAuth != nil {
+		if err := rpc.topicAuth(conn, topic); err != nil {
+			conn.send(packet.Error(&RemoteError{Code: ErrCodePermissionDenied, Message: err.Error()}))
+			return true
+		}
+	}
+	if method == SubscribeMethod {
+		rpc.subscribe(conn, topic)
+	} else {
+		rpc.unsubscribe(conn, topic)
+	}
+	// packet body can't be empty
+	conn.send(packet.reply(PT_RESPONSE, []byte("ok")))
+	return true
+}
+
+func (rpc *RPCServer) subscribe(conn *RPCConn, topic string) {
+	rpc.topicsLock.Lock()
+	defer rpc.topicsLock.Unlock()
+	subs, ok := rpc.topics[topic]
+	if !ok {
+		subs = make(map[*RPCConn]struct{})
+		rpc.topics[topic] = subs
+	}
+	subs[conn] = struct{}{}
+	conn.topics[topic] = struct{}{}
+}
+
+func (rpc *RPCServer) unsubscribe(conn *RPCConn, topic string) {
+	rpc.topicsLock.Lock()
+	defer rpc.topicsLock.Unlock()
+	rpc.dropSubscription(conn, topic)
+}
+
+// unsubscribeAll removes all subscriptions of disconnected session
+func (rpc *RPCServer) unsubscribeAll(conn *RPCConn) {
+	rpc.topicsLock.Lock()
+	defer rpc.topicsLock.Unlock()
+	for topic := range conn.topics {
+		rpc.dropSubscription(conn, topic)
+	}
+}
+
+// dropSubscription must be called with locked topics
+func (rpc *RPCServer) dropSubscription(conn *RPCConn, topic string) {
+	delete(conn.topics, topic)
+	if subs, ok := rpc.topics[topic]; ok {
+		delete(subs, conn)
+		if len(subs) == 0 {
+			delete(rpc.topics, topic)
+		}
+	}
+}
+
+// Subscribe subscribes client to topic, notifications published to topic
+// are passed to handler instead of notification handlers of client.
+// Subscriptions are restored after reconnect.
+func (cli *RPCClient) Subscribe(ctx context.Context, topic string, handler func(notification interface{})) error {
+	if err := checkTopic(topic); err != nil {
+		return err
+	}
+	if err := checkPublishTopic(cli.protDetails, topic); err != nil {
+		return err
+	}
+	if handler == nil {
+		return fmt.Errorf("nil handler of topic %s", topic)
+	}
+
+	// handler is set before request, so messages published right after
+	// subscription are not lost
+	cli.topicsLock.Lock()
+	prev, resubscribe := cli.topics[topic]
+	cli.topics[topic] = handler
+	cli.topicsLock.Unlock()
+
+	_, err := cli.roundTrip(ctx, NewPacket(PT_REQUEST, SubscribeMethod, []byte(topic)))
+	if err != nil {
+		cli.topicsLock.Lock()
+		if resubscribe {
+			cli.topics[topic] = prev
+		} else {
+			delete(cli.topics, topic)
+		}
+		cli.topicsLock.Unlock()
+	}
+	return err
+}
+
+// Unsubscribe unsubscribes client from topic
+func (cli *RPCClient) Unsubscribe(ctx context.Context, topic string) error {
+	if err := checkTopic(topic); err != nil {
+		return err
+	}
+	cli.topicsLock.Lock()
+	delete(cli.topics, topic)
+	cli.topicsLock.Unlock()
+
+	_, err := cli.roundTrip(ctx, NewPacket(PT_REQUEST, UnsubscribeMethod, []byte(topic)))
+	return err
+}
+
+// resubscribe restores subscriptions on new connection
+func (cli *RPCClient) resubscribe() {
+	cli.topicsLock.RLock()
+	topics := make([]string, 0, len(cli.topics))
+	for topic := range cli.topics {
+		topics = append(topics, topic)
+	}
+	cli.topicsLock.RUnlock()
+
+	for _, topic := range topics {
+		if _, err := cli.roundTrip(context.Background(), NewPacket(PT_REQUEST, SubscribeMethod, []byte(topic))); err != nil {
+			cli.log.Warningf("can't restore subscription to %s: %s", topic, err.Error())
+		}
+	}
+}
+
+// onPublished passes published notification to handler of its topic
+func (cli *RPCClient) onPublished(packet *Packet) {
+	name, topic, ok := parsePublishMethod(packet.Header.Method)
+	if !ok {
+		cli.log.Errorf("invalid published packet method %s", packet.Header.Method)
+		return
+	}
+	cli.topicsLock.RLock()
+	handler := cli.topics[topic]
+	cli.topicsLock.RUnlock()
+	if handler == nil {
+		// unsubscribed already
+		return
+	}
+
+	vt, ok := cli.protDetails.notifications[name]
+	if !ok {
+		cli.log.Warningf("unexpected notification %s in topic %s", name, topic)
+		return
+	}
+	val := reflect.New(vt)
+	if err := cli.codec.Unmarshal(packet.Body, val.Interface()); err != nil {
+		cli.log.Warningf("can't decode notification %s in topic %s: %s", name, topic, err.Error())
+		return
+	}
+	handler(val.Interface())
+}
@@ -0,0 +1,216 @@
+package wsrpc
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type NotificationWhichNameIsSoLongThatItLeavesNoRoomForLongTopicInMethodOfPublishedPacketBecauseMethodLengthIsStoredInSingleByteOfPacketHeader struct {
+	Msg string
+}
+
+type LongNotifProtocol struct {
+	SProt
+	Notifications struct {
+		*MyNotif
+		*NotificationWhichNameIsSoLongThatItLeavesNoRoomForLongTopicInMethodOfPublishedPacketBecauseMethodLengthIsStoredInSingleByteOfPacketHeader
+	}
+}
+
+func TestTopicMethodLength(t *testing.T) {
+	conns := make(chan RPCTransport)
+	srv, err := NewRPCServer(conns, func() SessionProtocol { return &LongNotifProtocol{} }, &DummyLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+
+	conn := NewFakeConn()
+	conns <- conn
+	conn.in <- NewPacket(PT_REQUEST, SubscribeMethod, []byte("news"))
+	if p := <-conn.out; p.Header.Type != PT_RESPONSE {
+		t.Fatalf("subscription response expected, got %s", p)
+	}
+
+	// method of long notification published to long topic doesn't fit into packet
+	topic := strings.Repeat("x", maxTopicLen)
+	req := NewPacket(PT_REQUEST, SubscribeMethod, []byte(topic))
+	conn.in <- req
+	if p := <-conn.out; p.Header.Type != PT_ERROR || !strings.Contains(string(p.Body), "topic is too long for notification") {
+		t.Fatalf("error expected, got %s", p)
+	}
+	if err := srv.Publish(topic, &NotificationWhichNameIsSoLongThatItLeavesNoRoomForLongTopicInMethodOfPublishedPacketBecauseMethodLengthIsStoredInSingleByteOfPacketHeader{}); err == nil || !strings.Contains(err.Error(), "topic is too long for notification") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := srv.Publish(topic, &MyNotif{"short"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Publish("news", &NotificationWhichNameIsSoLongThatItLeavesNoRoomForLongTopicInMethodOfPublishedPacketBecauseMethodLengthIsStoredInSingleByteOfPacketHeader{"long"}); err != nil {
+		t.Fatal(err)
+	}
+	if p := <-conn.out; p.Header.Type != PT_PUBLISH || !strings.HasSuffix(p.Header.Method, ":news") {
+		t.Fatalf("published notification expected, got %s", p)
+	}
+
+	// client checks topic before request is sent
+	cliConn := NewFakeConn()
+	cli, err := NewRPCClient(cliConn, &LongNotifProtocol{}, time.Second, nil, &DummyLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	err = cli.Subscribe(context.Background(), topic, func(interface{}) {})
+	if err == nil || !strings.Contains(err.Error(), "topic is too long for notification") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectNoPacket(t, cliConn)
+}
+
+func TestServerTopics(t *testing.T) {
+	closed := make(chan bool, 2)
+	conns := make(chan RPCTransport)
+	srv, err := NewRPCServer(conns, func() SessionProtocol { return &MyProtocol{closed: closed} }, &DummyLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+
+	subscriber, other := NewFakeConn(), NewFakeConn()
+	for _, fc := range []*FakeConn{subscriber, other} {
+		conns <- fc
+		<-fc.out // hello notification
+	}
+
+	req := NewPacket(PT_REQUEST, SubscribeMethod, []byte("news"))
+	subscriber.in <- req
+	if p := <-subscriber.out; p.Header.Type != PT_RESPONSE || p.Id() != req.Id() {
+		t.Fatalf("subscription response expected, got %s", p)
+	}
+	if n := srv.Subscribers("news"); n != 1 {
+		t.Fatalf("1 subscriber expected, got %d", n)
+	}
+
+	// only subscribers receive published notification
+	if err := srv.Publish("news", &MyNotif{"breaking"}); err != nil {
+		t.Fatal(err)
+	}
+	p := <-subscriber.out
+	if p.Header.Type != PT_PUBLISH || p.Header.Method != "MyNotif:news" || string(p.Body) != "{\"Msg\":\"breaking\"}" {
+		t.Fatalf("published notification expected, got %s", p)
+	}
+	expectNoPacket(t, other)
+
+	if err := srv.Publish("news", &SomeResp{}); err == nil || !strings.Contains(err.Error(), "is not declared in protocol") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// invalid topic
+	req = NewPacket(PT_REQUEST, SubscribeMethod, []byte(strings.Repeat("x", maxTopicLen+1)))
+	other.in <- req
+	if p := <-other.out; p.Header.Type != PT_ERROR || !strings.Contains(string(p.Body), "topic is longer than") {
+		t.Fatalf("error expected, got %s", p)
+	}
+
+	// unsubscription
+	other.in <- NewPacket(PT_REQUEST, SubscribeMethod, []byte("news"))
+	<-other.out
+	req = NewPacket(PT_REQUEST, UnsubscribeMethod, []byte("news"))
+	subscriber.in <- req
+	if p := <-subscriber.out; p.Header.Type != PT_RESPONSE || p.Id() != req.Id() {
+		t.Fatalf("unsubscription response expected, got %s", p)
+	}
+	srv.Publish("news", &MyNotif{"again"})
+	if p := <-other.out; p.Header.Type != PT_PUBLISH {
+		t.Fatalf("published notification expected, got %s", p)
+	}
+	expectNoPacket(t, subscriber)
+
+	// subscriptions are removed on disconnect
+	other.Close()
+	<-closed
+	if n := srv.Subscribers("news"); n != 0 {
+		t.Fatalf("no subscribers expected, got %d", n)
+	}
+}
+
+func TestClientTopics(t *testing.T) {
+	wsh := NewWsHandler(&DummyLogger{})
+	srv, err := NewRPCServer(wsh.Connections(), func() SessionProtocol { return &MyProtocol{} }, &DummyLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+	mux := http.NewServeMux()
+	mux.Handle("/test/wsrpc", wsh)
+	hs := &http.Server{Handler: mux, Addr: ":8092"}
+	go hs.ListenAndServe()
+	defer hs.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	cli, err := ClientWSRPC(&MyProtocol{}, "ws://127.0.0.1:8092/test/wsrpc", time.Second, nil, &DummyLogger{},
+		WithReconnect(ReconnectPolicy{MinBackoff: 10 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	received := make(chan string, 10)
+	err = cli.Subscribe(context.Background(), "news", func(n interface{}) {
+		received <- n.(*MyNotif).Msg
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPublished := func(msg string) {
+		t.Helper()
+		if err := srv.Publish("news", &MyNotif{msg}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case m := <-received:
+			if m != msg {
+				t.Fatalf("notification %s expected, got %s", msg, m)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("notification %s is not received", msg)
+		}
+	}
+	expectPublished("first")
+
+	srv.Publish("other", &MyNotif{"other"})
+	select {
+	case m := <-received:
+		t.Fatalf("unexpected notification %s", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// subscription is restored after reconnect
+	old := srv.Sessions()[0]
+	old.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		// subscriptions of session are removed before session itself
+		if _, ok := srv.Session(old.ID()); !ok && srv.Subscribers("news") == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	expectPublished("after reconnect")
+
+	if err := cli.Unsubscribe(context.Background(), "news"); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.Subscribers("news"); n != 0 {
+		t.Fatalf("no subscribers expected, got %d", n)
+	}
+
+	if err := cli.Subscribe(context.Background(), "", func(interface{}) {}); err == nil || err.Error() != "empty topic" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
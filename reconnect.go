@@ -0,0 +1,156 @@
+package wsrpc
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+var (
+	DisconnectedError = fmt.Errorf("client is disconnected")
+)
+
+// ReconnectPolicy configures automatic reconnection of RPCClient
+type ReconnectPolicy struct {
+	// Dial establishes new transport, ClientWSRPC dials the same url by default
+	Dial func() (RPCTransport, error)
+
+	MinBackoff  time.Duration // first reconnection delay (100ms by default)
+	MaxBackoff  time.Duration // maximal reconnection delay (30s by default)
+	MaxAttempts int           // attempts before client is closed (0 means unlimited)
+
+	// QueueCalls holds calls made while client is disconnected and sends
+	// them after reconnect, otherwise such calls fail immediately. Calls
+	// written to lost connection fail anyway, they may be processed by server.
+	QueueCalls bool
+
+	OnDisconnect func(err error)
+	OnReconnect  func()
+}
+
+// WithReconnect makes RPCClient to redial broken connection
+func WithReconnect(policy ReconnectPolicy) Option {
+	return func(o *options) {
+		if policy.MinBackoff <= 0 {
+			policy.MinBackoff = 100 * time.Millisecond
+		}
+		if policy.MaxBackoff < policy.MinBackoff {
+			policy.MaxBackoff = 30 * time.Second
+		}
+		o.reconnect = &policy
+	}
+}
+
+// withDefaultDial sets dial function of reconnect policy if it is not set
+func withDefaultDial(dial func() (RPCTransport, error)) Option {
+	return func(o *options) {
+		if o.reconnect != nil && o.reconnect.Dial == nil {
+			o.reconnect.Dial = dial
+		}
+	}
+}
+
+// backoff returns jittered exponential delay before reconnection attempt
+func (p *ReconnectPolicy) backoff(attempt int) time.Duration {
+	d := p.MaxBackoff
+	if attempt < 32 && p.MinBackoff<<uint(attempt) < p.MaxBackoff {
+		d = p.MinBackoff << uint(attempt)
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+type pendingRequest struct {
+	seq     uint64
+	packet  *Packet
+	written bool // write to connection is attempted
+}
+
+// failWritten fails queued calls which are written to lost connection,
+// they aren't resent because server may have processed them already
+func (cli *RPCClient) failWritten(err error) {
+	var lost []string
+	cli.connLock.Lock()
+	for mid, pr := range cli.pending {
+		if pr.written {
+			delete(cli.pending, mid)
+			lost = append(lost, mid)
+		}
+	}
+	cli.connLock.Unlock()
+
+	for _, mid := range lost {
+		if w := cli.flow.GetWaiter(mid); w != nil {
+			w.setError(err)
+		}
+	}
+}
+
+// redial establishes new connection after disconnect,
+// false is returned if client is closed or attempts are exhausted
+func (cli *RPCClient) redial(reason error) bool {
+	p := cli.reconnect
+	if p.OnDisconnect != nil {
+		p.OnDisconnect(reason)
+	}
+	// streams can't be resumed on new connection
+	cli.failStreams(fmt.Errorf("connection lost: %w", reason))
+	if !p.QueueCalls {
+		cli.flow.FailAll(fmt.Errorf("connection lost: %w", reason))
+	} else {
+		cli.failWritten(fmt.Errorf("connection lost: %w", reason))
+	}
+
+	for attempt := 0; p.MaxAttempts == 0 || attempt < p.MaxAttempts; attempt++ {
+		select {
+		case <-time.After(p.backoff(attempt)):
+		case <-cli.closeCh:
+			return false
+		}
+
+		conn, err := p.Dial()
+		if err != nil {
+			cli.log.Debugf("reconnection attempt #%d failed: %s", attempt+1, err.Error())
+			continue
+		}
+		if codec, err := selectCodec(cli.codecs, conn); err != nil || codec != cli.codec {
+			cli.log.Errorf("reconnection attempt #%d failed: codec %s is not negotiated", attempt+1, cli.codec.Name())
+			conn.Close()
+			continue
+		}
+
+		cli.connLock.Lock()
+		if cli.closing {
+			cli.connLock.Unlock()
+			conn.Close()
+			return false
+		}
+		cli.conn = conn
+		cli.connected = true
+		pending := make([]pendingRequest, 0, len(cli.pending))
+		for mid, pr := range cli.pending {
+			pr.written = true
+			cli.pending[mid] = pr
+			pending = append(pending, pr)
+		}
+		cli.connLock.Unlock()
+
+		// send queued requests in original order
+		sort.Slice(pending, func(i, j int) bool { return pending[i].seq < pending[j].seq })
+		for _, pr := range pending {
+			if err := conn.Send(pr.packet); err != nil {
+				cli.log.Debugf("can't send queued request %s: %s", pr.packet.Id(), err.Error())
+				cli.unwritten(pr.packet.Id(), conn)
+			}
+		}
+
+		cli.log.Infof("rpc client reconnected after %d attempt(s)", attempt+1)
+		// responses are received after read loop is restarted
+		go cli.resubscribe()
+		if p.OnReconnect != nil {
+			p.OnReconnect()
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package wsrpc
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startTestServer(addr string) chan struct{} {
+	closech := make(chan struct{})
+	go ServeWSRPC(func() SessionProtocol { return &MyProtocol{} }, addr, "/test/wsrpc", &DummyLogger{}, closech)
+	time.Sleep(100 * time.Millisecond)
+	return closech
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	p := &ReconnectPolicy{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		for i := 0; i < 10; i++ {
+			d := p.backoff(attempt)
+			if d < max/2 || d > max {
+				t.Fatalf("attempt #%d: backoff %s is out of [%s, %s]", attempt, d, max/2, max)
+			}
+		}
+	}
+	if d := p.backoff(100); d > time.Second {
+		t.Fatalf("backoff %s exceeds max backoff", d)
+	}
+
+	_, err := NewRPCClient(NewFakeConn(), &MyProtocol{}, time.Second, nil, &DummyLogger{}, WithReconnect(ReconnectPolicy{}))
+	if err == nil || err.Error() != "reconnect policy without Dial function" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestReconnectFailInFlight(t *testing.T) {
+	closech := startTestServer(":8085")
+
+	disconnected := make(chan error, 1)
+	reconnected := make(chan struct{}, 1)
+	policy := ReconnectPolicy{
+		MinBackoff:   50 * time.Millisecond,
+		MaxBackoff:   200 * time.Millisecond,
+		OnDisconnect: func(err error) { disconnected <- err },
+		OnReconnect:  func() { reconnected <- struct{}{} },
+	}
+	cli, err := ClientWSRPC(&MyProtocol{}, "ws://127.0.0.1:8085/test/wsrpc", 5*time.Second, nil, &DummyLogger{}, WithReconnect(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	// in-flight call fails on disconnect
+	errch := make(chan error)
+	go func() {
+		_, err := cli.Call("MyWait", &SomeReq{"Bob"})
+		errch <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(closech)
+
+	select {
+	case err = <-errch:
+		if err == nil || !strings.HasPrefix(err.Error(), "connection lost") {
+			t.Fatalf("connection lost error expected, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("in-flight call is not failed on disconnect")
+	}
+	<-disconnected
+
+	// new calls fail while client is disconnected
+	_, err = cli.Call("MyMethod", &SomeReq{"Bob"})
+	if err != DisconnectedError {
+		t.Fatalf("disconnected error expected, got %v", err)
+	}
+	if cli.Closed() {
+		t.Fatal("reconnecting client must not be closed")
+	}
+
+	// the same client handle works after reconnect
+	closech = startTestServer(":8085")
+	defer close(closech)
+	select {
+	case <-reconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client is not reconnected")
+	}
+	resp, err := cli.Call("MyMethod", &SomeReq{"Bob"})
+	if err != nil || !resp.(*SomeResp).IsBob {
+		t.Fatalf("unexpected result after reconnect: %v, %v", resp, err)
+	}
+}
+
+func TestReconnectQueueCalls(t *testing.T) {
+	closech := startTestServer(":8086")
+
+	disconnected := make(chan error, 1)
+	policy := ReconnectPolicy{
+		MinBackoff:   50 * time.Millisecond,
+		MaxBackoff:   100 * time.Millisecond,
+		QueueCalls:   true,
+		OnDisconnect: func(err error) { disconnected <- err },
+	}
+	cli, err := ClientWSRPC(&MyProtocol{}, "ws://127.0.0.1:8086/test/wsrpc", 5*time.Second, nil, &DummyLogger{}, WithReconnect(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	// in-flight call isn't resent after reconnect, server may have processed it
+	inflight := make(chan error)
+	go func() {
+		_, err := cli.Call("MyWait", &SomeReq{"Bob"})
+		inflight <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(closech)
+	<-disconnected
+
+	// calls are queued while client is disconnected
+	queued := make(chan error)
+	go func() {
+		resp, err := cli.Call("MyMethod", &SomeReq{"Bob"})
+		if err == nil && !resp.(*SomeResp).IsBob {
+			err = errors.New("unexpected response")
+		}
+		queued <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	closech = startTestServer(":8086")
+	defer close(closech)
+
+	for ch, lost := range map[chan error]bool{queued: false, inflight: true} {
+		select {
+		case err = <-ch:
+			if lost && (err == nil || !strings.HasPrefix(err.Error(), "connection lost")) {
+				t.Fatalf("connection lost error expected, got %v", err)
+			}
+			if !lost && err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(4 * time.Second):
+			t.Fatal("queued call is not completed after reconnect")
+		}
+	}
+	if len(cli.pending) != 0 {
+		t.Fatalf("pending requests must be forgotten, got %d", len(cli.pending))
+	}
+}
+
+func TestReconnectAttemptsExhausted(t *testing.T) {
+	closech := startTestServer(":8087")
+
+	policy := ReconnectPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond, MaxAttempts: 3}
+	cli, err := ClientWSRPC(&MyProtocol{}, "ws://127.0.0.1:8087/test/wsrpc", 5*time.Second, nil, &DummyLogger{}, WithReconnect(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(closech)
+
+	time.Sleep(500 * time.Millisecond)
+	if !cli.Closed() {
+		t.Fatal("client must be closed after reconnection attempts are exhausted")
+	}
+}
@@ -1,23 +1,105 @@
 package wsrpc
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"strings"
 )
 
 type methodDetails struct {
 	funcVal reflect.Value
 	inType  reflect.Type
-	outType reflect.Type
+	outType reflect.Type // response type or item type of streaming method
+	withCtx bool         // method accepts context.Context as first argument
+
+	streaming  bool         // method streams items to client
+	recvType   reflect.Type // type of items streamed by client, nil if method doesn't receive them
+	streamType reflect.Type // stream argument type, nil if method returns channel
+}
+
+// isStream checks method streams items in any direction
+func (md methodDetails) isStream() bool {
+	return md.streaming || md.recvType != nil
+}
+
+// call invokes method of protocol session p with given request
+func (md methodDetails) call(ctx context.Context, p SessionProtocol, req interface{}) (interface{}, error) {
+	if req == nil || reflect.TypeOf(req) != reflect.PtrTo(md.inType) {
+		return nil, fmt.Errorf("invalid request type, *%s expected", md.inType.Name())
+	}
+	args := []reflect.Value{reflect.ValueOf(p), reflect.ValueOf(req)}
+	if md.withCtx {
+		args = []reflect.Value{reflect.ValueOf(p), reflect.ValueOf(ctx), reflect.ValueOf(req)}
+	}
+	ret := md.funcVal.Call(args)
+
+	var err error
+	if !ret[1].IsNil() { // check error
+		err = ret[1].Interface().(error)
+	}
+	return ret[0].Interface(), err
+}
+
+// callStream invokes streaming method of protocol session p with stream st,
+// response is returned only by client streaming method
+func (md methodDetails) callStream(ctx context.Context, p SessionProtocol, req interface{}, st *serverStream) (interface{}, error) {
+	if req == nil || reflect.TypeOf(req) != reflect.PtrTo(md.inType) {
+		return nil, fmt.Errorf("invalid request type, *%s expected", md.inType.Name())
+	}
+	if md.streamType == nil {
+		ch, err := md.call(ctx, p, req)
+		if err != nil {
+			return nil, err
+		}
+		return nil, st.sendFrom(reflect.ValueOf(ch))
+	}
+
+	args := []reflect.Value{reflect.ValueOf(p), reflect.ValueOf(req)}
+	if md.withCtx {
+		args = []reflect.Value{reflect.ValueOf(p), reflect.ValueOf(ctx), reflect.ValueOf(req)}
+	}
+	if md.recvType != nil {
+		// client waits for credits to send items
+		st.grantWindow()
+	}
+	stream := reflect.Zero(md.streamType).Interface().(streamParam).withState(st)
+	ret := md.funcVal.Call(append(args, reflect.ValueOf(stream)))
+	if errV := ret[len(ret)-1]; !errV.IsNil() {
+		return nil, errV.Interface().(error)
+	}
+	if len(ret) == 2 {
+		return ret[0].Interface(), nil
+	}
+	return nil, nil
+}
+
+// clientNotifDetails describes notification of client and its server side handler
+type clientNotifDetails struct {
+	nType   reflect.Type
+	handler reflect.Value
+}
+
+// call invokes handler of notification n in protocol session p
+func (nd clientNotifDetails) call(p SessionProtocol, n interface{}) {
+	nd.handler.Call([]reflect.Value{reflect.ValueOf(p), reflect.ValueOf(n)})
 }
 
 type protocolDetails struct {
-	methods       map[string]methodDetails
-	notifications map[string]reflect.Type
+	methods             map[string]methodDetails
+	notifications       map[string]reflect.Type
+	clientNotifications map[string]clientNotifDetails
+	errors              map[string]reflect.Type
+	phases              map[string]map[Phase]struct{} // phases of restricted methods (server side)
 }
 
+var (
+	errorInterface   = reflect.TypeOf((*error)(nil)).Elem()
+	contextInterface = reflect.TypeOf((*context.Context)(nil)).Elem()
+	streamInterface  = reflect.TypeOf((*streamParam)(nil)).Elem()
+)
+
 func parseSessionProtocol(p SessionProtocol) (*protocolDetails, error) {
-	errorInterface := reflect.TypeOf((*error)(nil)).Elem()
 	if p == nil {
 		return nil, fmt.Errorf("pointer to protocol instance expected")
 	}
@@ -35,49 +117,117 @@ func parseSessionProtocol(p SessionProtocol) (*protocolDetails, error) {
 	}
 	ret.notifications = ndescr
 
+	edescr, err := parseErrors(pType.Elem())
+	if err != nil {
+		return nil, err
+	}
+	ret.errors = edescr
+
+	cndescr, err := parseClientNotifications(pType)
+	if err != nil {
+		return nil, err
+	}
+	ret.clientNotifications = cndescr
+
 	for i := 0; i < pType.NumMethod(); i++ {
 		m := pType.Method(i)
 		switch m.Name {
 		case "OnConnect", "OnDisconnect":
 			continue
+		case "Phases":
+			if _, ok := p.(PhasedSession); ok {
+				continue
+			}
 		default:
 		}
+		if name := strings.TrimPrefix(m.Name, "On"); name != m.Name {
+			if _, ok := cndescr[name]; ok {
+				// handler of client notification
+				continue
+			}
+		}
 		//fmt.Printf("method #%d: name=%s, type=%s, func=%s\n", i, m.Name, m.Type, m.Func)
 
-		// check inputs
-		if m.Type.NumIn() != 2 {
-			return nil, fmt.Errorf("one input structure expected in method %s", m.Name)
+		md, err := parseMethod(m)
+		if err != nil {
+			return nil, err
 		}
+		ret.methods[m.Name] = md
+	}
+	return ret, nil
+}
 
-		in := m.Type.In(1)
-		if in.Kind() != reflect.Ptr {
-			return nil, fmt.Errorf("input must be a pointer in method %s", m.Name)
-		}
-		inT := in.Elem()
-		if inT.Kind() != reflect.Struct {
-			return nil, fmt.Errorf("input must be a pointer to struct in method %s", m.Name)
-		}
+// parseMethod checks method signature, supported signatures are
+//
+//	func (p *P) Method([ctx context.Context,] req *Req) (*Resp, error)
+//	func (p *P) Method([ctx context.Context,] req *Req, s wsrpc.Stream[*Item]) error
+//	func (p *P) Method([ctx context.Context,] req *Req) (<-chan *Item, error)
+//	func (p *P) Method([ctx context.Context,] req *Req, s wsrpc.RecvStream[*Item]) (*Resp, error)
+//	func (p *P) Method([ctx context.Context,] req *Req, s wsrpc.BidiStream[*In, *Out]) error
+func parseMethod(m reflect.Method) (methodDetails, error) {
+	md := methodDetails{funcVal: m.Func}
 
-		// check outputs
-		if m.Type.NumOut() != 2 {
-			return nil, fmt.Errorf("expected response and error as output in method %s", m.Name)
-		}
-		out := m.Type.Out(0)
-		if out.Kind() != reflect.Ptr {
-			return nil, fmt.Errorf("output must be a pointer in method %s", m.Name)
+	// check inputs
+	numIn := m.Type.NumIn()
+	if numIn > 2 && m.Type.In(numIn-1).Implements(streamInterface) {
+		md.streamType = m.Type.In(numIn - 1)
+		numIn--
+	}
+	md.withCtx = numIn == 3 && m.Type.In(1) == contextInterface
+	if numIn != 2 && !md.withCtx {
+		return md, fmt.Errorf("one input structure expected in method %s", m.Name)
+	}
+
+	in := m.Type.In(numIn - 1)
+	if in.Kind() != reflect.Ptr {
+		return md, fmt.Errorf("input must be a pointer in method %s", m.Name)
+	}
+	md.inType = in.Elem()
+	if md.inType.Kind() != reflect.Struct {
+		return md, fmt.Errorf("input must be a pointer to struct in method %s", m.Name)
+	}
+
+	// check outputs
+	if md.streamType != nil {
+		recv, send := reflect.Zero(md.streamType).Interface().(streamParam).streamTypes()
+		for _, item := range []reflect.Type{recv, send} {
+			if item != nil && (item.Kind() != reflect.Ptr || item.Elem().Kind() != reflect.Struct) {
+				return md, fmt.Errorf("stream item must be a pointer to struct in method %s", m.Name)
+			}
 		}
-		outT := out.Elem()
-		if outT.Kind() != reflect.Struct {
-			return nil, fmt.Errorf("output must be a pointer to struct in method %s", m.Name)
+		if recv != nil {
+			md.recvType = recv.Elem()
 		}
-		out = m.Type.Out(1)
-		if !out.Implements(errorInterface) {
-			return nil, fmt.Errorf("method must return error type in method %s", m.Name)
+		if send != nil {
+			if m.Type.NumOut() != 1 || !m.Type.Out(0).Implements(errorInterface) {
+				return md, fmt.Errorf("stream method must return only error in method %s", m.Name)
+			}
+			md.outType = send.Elem()
+			md.streaming = true
+			return md, nil
 		}
+	}
 
-		ret.methods[m.Name] = methodDetails{m.Func, inT, outT}
+	if m.Type.NumOut() != 2 {
+		return md, fmt.Errorf("expected response and error as output in method %s", m.Name)
 	}
-	return ret, nil
+	out := m.Type.Out(0)
+	if out.Kind() == reflect.Chan && md.streamType == nil && out.ChanDir()&reflect.RecvDir != 0 {
+		// items are received from returned channel
+		md.streaming = true
+		out = out.Elem()
+	}
+	if out.Kind() != reflect.Ptr {
+		return md, fmt.Errorf("output must be a pointer in method %s", m.Name)
+	}
+	md.outType = out.Elem()
+	if md.outType.Kind() != reflect.Struct {
+		return md, fmt.Errorf("output must be a pointer to struct in method %s", m.Name)
+	}
+	if !m.Type.Out(1).Implements(errorInterface) {
+		return md, fmt.Errorf("method must return error type in method %s", m.Name)
+	}
+	return md, nil
 }
 
 func parseNotifications(pt reflect.Type) (map[string]reflect.Type, error) {
@@ -104,3 +254,58 @@ func parseNotifications(pt reflect.Type) (map[string]reflect.Type, error) {
 	return ret, nil
 
 }
+
+func parseErrors(pt reflect.Type) (map[string]reflect.Type, error) {
+	ret := make(map[string]reflect.Type)
+	field, ok := pt.FieldByName("Errors")
+	if !ok {
+		// errors declaration is optional
+		return ret, nil
+	}
+
+	es := field.Type
+	if es.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("Errors must be declared as a struct")
+	}
+
+	for i := 0; i < es.NumField(); i++ {
+		f := es.Field(i)
+		if f.Type.Kind() != reflect.Ptr || f.Type.Elem().Kind() != reflect.Struct {
+			return nil, fmt.Errorf("error %s must be a pointer to struct", f.Name)
+		}
+		if !f.Type.Implements(errorInterface) {
+			return nil, fmt.Errorf("error %s must implement error interface", f.Name)
+		}
+		ret[f.Type.Elem().Name()] = f.Type.Elem()
+	}
+	return ret, nil
+}
+
+// parseClientNotifications parses optional ClientNotifications declaration,
+// every client notification N must have handler method OnN(*N) in protocol
+func parseClientNotifications(pType reflect.Type) (map[string]clientNotifDetails, error) {
+	ret := make(map[string]clientNotifDetails)
+	field, ok := pType.Elem().FieldByName("ClientNotifications")
+	if !ok {
+		// client notifications declaration is optional
+		return ret, nil
+	}
+
+	ns := field.Type
+	if ns.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("ClientNotifications must be declared as a struct")
+	}
+	for i := 0; i < ns.NumField(); i++ {
+		f := ns.Field(i)
+		if f.Type.Kind() != reflect.Ptr || f.Type.Elem().Kind() != reflect.Struct {
+			return nil, fmt.Errorf("client notification %s must be a pointer to struct", f.Name)
+		}
+		n := f.Type.Elem()
+		m, ok := pType.MethodByName("On" + n.Name())
+		if !ok || m.Type.NumIn() != 2 || m.Type.In(1) != f.Type || m.Type.NumOut() != 0 {
+			return nil, fmt.Errorf("handler On%s(*%s) expected for client notification %s", n.Name(), n.Name(), n.Name())
+		}
+		ret[n.Name()] = clientNotifDetails{n, m.Func}
+	}
+	return ret, nil
+}
@@ -1,6 +1,7 @@
 package wsrpc
 
 import (
+	"context"
 	"testing"
 )
 
@@ -95,13 +96,61 @@ func (p *SProtWithErrMethod7) InvalidMethod(r *ReqTest) (*RespTest, int) {
 	return &RespTest{66}, 55
 }
 
+type SProtWithErrErrors struct {
+	SProt
+	Notifications struct{}
+	Errors        []error
+}
+
+type SProtWithErrErrors2 struct {
+	SProt
+	Notifications struct{}
+	Errors        struct {
+		NTest
+	}
+}
+
+type SProtWithErrErrors3 struct {
+	SProt
+	Notifications struct{}
+	Errors        struct {
+		*NTest
+	}
+}
+
+type SProtWithErrClientNotifs struct {
+	SProt
+	Notifications       struct{}
+	ClientNotifications []int
+}
+
+type SProtWithErrClientNotifs2 struct {
+	SProt
+	Notifications       struct{}
+	ClientNotifications struct {
+		*NTest
+	}
+}
+
+func (p *SProtWithErrClientNotifs2) OnNTest(n *NTest) error { return nil }
+
+type ETest struct{ Code int }
+
+func (e *ETest) Error() string { return "test error" }
+
 type SProtWithValidMethods struct {
 	SProt
 	Notifications struct {
 		*NTest
 	}
+	Errors struct {
+		*ETest
+	}
 }
 
+func (p *SProtWithValidMethods) ThirdMethod(ctx context.Context, r *ReqTest) (*RespTest, error) {
+	return &RespTest{55}, nil
+}
 func (p *SProtWithValidMethods) somePrivate()                               {}
 func (p *SProtWithValidMethods) FirstMethod(r *ReqTest) (*RespTest, error)  { return &RespTest{77}, nil }
 func (p *SProtWithValidMethods) SecondMethod(r *ReqTest) (*RespTest, error) { return &RespTest{66}, nil }
@@ -138,6 +187,42 @@ func TestSessionProtocolReflection(t *testing.T) {
 		t.Fatalf("unexpected error: %s", err)
 	}
 
+	pd, err = parseSessionProtocol(&SProtWithErrErrors{})
+	if err.Error() != "Errors must be declared as a struct" {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	pd, err = parseSessionProtocol(&SProtWithErrErrors2{})
+	if err.Error() != "error NTest must be a pointer to struct" {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	pd, err = parseSessionProtocol(&SProtWithErrErrors3{})
+	if err.Error() != "error NTest must implement error interface" {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	pd, err = parseSessionProtocol(&SProtWithErrClientNotifs{})
+	if err.Error() != "ClientNotifications must be declared as a struct" {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	pd, err = parseSessionProtocol(&SProtWithErrClientNotifs2{})
+	if err.Error() != "handler OnNTest(*NTest) expected for client notification NTest" {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	pd, err = parseSessionProtocol(&MyProtocol{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if nd, ok := pd.clientNotifications["TypingNotif"]; !ok || nd.nType.Name() != "TypingNotif" {
+		t.Fatal("TypingNotif client notification not found")
+	}
+	if _, ok := pd.methods["OnTypingNotif"]; ok {
+		t.Fatal("client notification handler must not be parsed as method")
+	}
+
 	pd, err = parseSessionProtocol(&SProtWithErrMethod{})
 	if err.Error() != "one input structure expected in method InvalidMethod" {
 		t.Fatalf("unexpected error: %s", err)
@@ -178,8 +263,8 @@ func TestSessionProtocolReflection(t *testing.T) {
 		t.Fatalf("unexpected error: %s", err)
 	}
 
-	if len(pd.methods) != 2 {
-		t.Fatal("expected 2 parsed methods")
+	if len(pd.methods) != 3 {
+		t.Fatal("expected 3 parsed methods")
 	}
 	if _, ok := pd.methods["FirstMethod"]; !ok {
 		t.Fatal("FirstMethod not found")
@@ -187,6 +272,12 @@ func TestSessionProtocolReflection(t *testing.T) {
 	if _, ok := pd.methods["SecondMethod"]; !ok {
 		t.Fatal("SecondMethod not found")
 	}
+	if md, ok := pd.methods["ThirdMethod"]; !ok || !md.withCtx || md.inType.Name() != "ReqTest" {
+		t.Fatal("ThirdMethod with context not found")
+	}
+	if pd.methods["FirstMethod"].withCtx {
+		t.Fatal("FirstMethod has no context")
+	}
 
 	if len(pd.notifications) != 1 {
 		t.Fatal("expected 1 parsed notification")
@@ -194,4 +285,11 @@ func TestSessionProtocolReflection(t *testing.T) {
 	if _, ok := pd.notifications["NTest"]; !ok {
 		t.Fatal("NTest notification not found")
 	}
+
+	if len(pd.errors) != 1 {
+		t.Fatal("expected 1 parsed error")
+	}
+	if _, ok := pd.errors["ETest"]; !ok {
+		t.Fatal("ETest error not found")
+	}
 }
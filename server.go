@@ -1,46 +1,225 @@
 package wsrpc
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"reflect"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
 )
 
 // RPCConn implements notifications sender from server to client and connection closer
 type RPCConn struct {
+	id          string
+	identity    interface{} // identity of authenticated client
+	info        ConnInfo
 	protDetails *protocolDetails
-	notifChan   chan *Packet
+	codec       Codec
+	out         *outboundQueue // packets waiting for session sender
 	closer      io.Closer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	callsLock sync.Mutex
+	calls     map[string]context.CancelFunc // running requests
+
+	flow *FlowController // waiters of requests to client
+
+	streams map[string]*serverStream // running streaming calls, guarded by callsLock
+
+	topics map[string]struct{} // subscriptions, guarded by topicsLock of server
+
+	phaseLock sync.Mutex
+	phase     Phase // current phase of phased session
+
+	orderedLock  sync.Mutex
+	orderedQueue []job // ordered requests waiting for previous one
+	orderedBusy  bool  // ordered request is processing now
+}
+
+// ID returns unique session identifier, it doesn't change during session life
+func (c *RPCConn) ID() string {
+	return c.id
+}
+
+// Info returns details of session connection
+func (c *RPCConn) Info() ConnInfo {
+	return c.info
+}
+
+// Identity returns identity of client if transport authenticates it
+// (see WithAuthenticator), nil otherwise
+func (c *RPCConn) Identity() interface{} {
+	return c.identity
+}
+
+// Context returns session context which is cancelled when session disconnects
+func (c *RPCConn) Context() context.Context {
+	return c.ctx
+}
+
+// startCall registers running request which can be cancelled by client
+func (c *RPCConn) startCall(mid string) context.Context {
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.callsLock.Lock()
+	c.calls[mid] = cancel
+	c.callsLock.Unlock()
+	return ctx
+}
+
+// openStream registers stream of streaming request
+func (c *RPCConn) openStream(ctx context.Context, packet *Packet, md methodDetails, window int) {
+	c.callsLock.Lock()
+	c.streams[packet.Id()] = newServerStream(ctx, c, packet, md, window)
+	c.callsLock.Unlock()
+}
+
+// stream returns stream of running streaming request
+func (c *RPCConn) stream(mid string) *serverStream {
+	c.callsLock.Lock()
+	defer c.callsLock.Unlock()
+	return c.streams[mid]
+}
+
+// finishCall unregisters completed request, false is returned if request
+// was cancelled and its response must be suppressed
+func (c *RPCConn) finishCall(mid string) bool {
+	c.callsLock.Lock()
+	cancel, ok := c.calls[mid]
+	delete(c.calls, mid)
+	delete(c.streams, mid)
+	c.callsLock.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
 }
 
+// cancelCall cancels context of running request
+func (c *RPCConn) cancelCall(mid string) bool {
+	c.callsLock.Lock()
+	cancel, ok := c.calls[mid]
+	delete(c.calls, mid)
+	delete(c.streams, mid)
+	c.callsLock.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// send passes packet to session sender, packet is dropped if session is closed
+func (c *RPCConn) send(p *Packet) {
+	c.out.push(c.ctx, p)
+}
+
+// Notify queues notification for sending to client. When outbound queue
+// of session is full, notification is handled according to slow consumer
+// policy (see WithOutboundQueue) and error is returned if it is rejected.
 func (c *RPCConn) Notify(notification interface{}) error {
+	p, err := newNotificationPacket(c.protDetails, c.codec, notification)
+	if err != nil {
+		return err
+	}
+	return c.sendNotification(p, true)
+}
+
+// sendNotification queues notification packet according to slow consumer policy,
+// BlockSender policy rejects notification instead of waiting if wait is false
+func (c *RPCConn) sendNotification(p *Packet, wait bool) error {
+	var err error
+	if wait {
+		err = c.out.push(c.ctx, p)
+	} else {
+		err = c.out.offer(c.ctx, p)
+	}
+	if err == SlowConsumerError {
+		// closing may wait for write of stalled sender
+		go c.Close()
+	}
+	return err
+}
+
+// newNotificationPacket checks notification is declared in protocol and encodes it with codec
+func newNotificationPacket(pd *protocolDetails, codec Codec, notification interface{}) (*Packet, error) {
 	// check type of notification
 	nt := reflect.TypeOf(notification)
-	if nt.Kind() == reflect.Ptr {
+	if nt != nil && nt.Kind() == reflect.Ptr {
 		nt = nt.Elem()
 	}
-	vt, ok := c.protDetails.notifications[nt.Name()]
+	if nt == nil {
+		return nil, fmt.Errorf("Notification %s is not declared in protocol", nt)
+	}
+	vt, ok := pd.notifications[nt.Name()]
 	if !ok || vt != nt {
-		return fmt.Errorf("Notification %s is not declared in protocol", reflect.TypeOf(notification))
+		return nil, fmt.Errorf("Notification %s is not declared in protocol", reflect.TypeOf(notification))
 	}
 
 	// marshal notification to []byte
-	buf, err := json.Marshal(notification)
+	buf, err := codec.Marshal(notification)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return NewPacket(PT_NOTIFICATION, nt.Name(), buf), nil
+}
+
+// Call sends request to client and waits for response until ctx is done or
+// call timeout expires. Request is processed by method of client session
+// protocol. Call must not be used in OnConnect, responses are received
+// after OnConnect returns.
+func (c *RPCConn) Call(ctx context.Context, method string, request interface{}) (interface{}, error) {
+	md, ok := c.protDetails.methods[method]
+	if !ok {
+		return nil, fmt.Errorf("unknown method %s", method)
+	}
+	if md.isStream() {
+		return nil, fmt.Errorf("streaming method %s can't be called by server", method)
+	}
+	if request == nil || reflect.TypeOf(request) != reflect.PtrTo(md.inType) {
+		return nil, fmt.Errorf("invalid request type, *%s expected", md.inType.Name())
 	}
 
-	p := NewPacket(PT_NOTIFICATION, nt.Name(), buf)
-	select {
-	case c.notifChan <- p:
-	default:
-		go func() {
-			c.notifChan <- p
-		}()
+	reqBody, err := c.codec.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	reqPacket := NewPacket(PT_REQUEST, method, reqBody)
+
+	rid := reqPacket.Id()
+	var rw *Waiter
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		rw = c.flow.NewWaiterWithDeadline(rid, deadline)
+	} else {
+		rw = c.flow.NewWaiter(rid)
+	}
+	c.send(reqPacket)
+
+	respPacket, err := rw.WaitContext(ctx)
+	if err != nil {
+		if err == TimeoutError && hasDeadline {
+			// waiter timeouts at context deadline
+			err = context.DeadlineExceeded
+		}
+		if ctx.Err() != nil || err == TimeoutError {
+			// ask client to stop processing of abandoned call
+			c.flow.GetWaiter(rid)
+			c.send(reqPacket.Cancel(err))
+		}
+		return nil, err
 	}
 
-	return nil
+	outV := reflect.New(md.outType)
+	err = c.codec.Unmarshal(respPacket.Body, outV.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return outV.Interface(), nil
 }
 
 func (c *RPCConn) Close() error {
@@ -51,22 +230,60 @@ func (c *RPCConn) Close() error {
 type RPCServer struct {
 	conns <-chan RPCTransport
 
-	protocol NewSessionFunc
+	protocol       NewSessionFunc
+	sessionFactory func(info ConnInfo) SessionProtocol
 
 	protDetails *protocolDetails
 	wp          *workersPool
 	finishCh    chan struct{}
+	codecs      []Codec
+
+	orderedSessions bool
+	orderedMethods  map[string]bool
+	queueSize       int
+	callTimeout     time.Duration
+	streamWindow    int
+	outQueueSize    int
+	slowConsumer    SlowConsumerPolicy
+
+	sessionsLock sync.RWMutex
+	sessions     map[string]*RPCConn // connected sessions by ID
+
+	topicsLock sync.RWMutex
+	topics     map[string]map[*RPCConn]struct{} // subscribers by topic
+	topicAuth  TopicAuthorizer
 
 	log Logger
 }
 
 type NewSessionFunc func() SessionProtocol
 
-func NewRPCServer(conns <-chan RPCTransport, f NewSessionFunc, log Logger) (*RPCServer, error) {
+// PanicHandler is called with recovered value and stack trace when protocol method panics
+type PanicHandler func(conn *RPCConn, method string, recovered interface{}, stack []byte)
+
+func NewRPCServer(conns <-chan RPCTransport, f NewSessionFunc, log Logger, opts ...Option) (*RPCServer, error) {
+	o := newOptions(opts)
+	if f == nil && o.sessionFactory != nil {
+		f = func() SessionProtocol { return o.sessionFactory(ConnInfo{}) }
+	}
+	if f == nil {
+		return nil, fmt.Errorf("session constructor is not set")
+	}
 	rpc := &RPCServer{
-		conns:    conns,
-		finishCh: make(chan struct{}),
-		log:      log,
+		conns:           conns,
+		finishCh:        make(chan struct{}),
+		codecs:          o.codecs,
+		orderedSessions: o.orderedSessions,
+		orderedMethods:  o.orderedMethods,
+		queueSize:       o.queueSize,
+		callTimeout:     o.callTimeout,
+		streamWindow:    o.streamWindow,
+		outQueueSize:    o.outboundQueueSize,
+		slowConsumer:    o.slowConsumer,
+		sessions:        make(map[string]*RPCConn),
+		topics:          make(map[string]map[*RPCConn]struct{}),
+		topicAuth:       o.topicAuthorizer,
+		log:             log,
 	}
 
 	p := f()
@@ -74,9 +291,21 @@ func NewRPCServer(conns <-chan RPCTransport, f NewSessionFunc, log Logger) (*RPC
 	if err != nil {
 		return nil, err
 	}
-	rpc.wp = &workersPool{jobs: make(chan job), log: log, protDetails: pdetails}
+	for m := range o.orderedMethods {
+		if _, ok := pdetails.methods[m]; !ok {
+			return nil, fmt.Errorf("unknown ordered method %s", m)
+		}
+	}
+	if err := checkMethodRoles(o.methodRoles, pdetails); err != nil {
+		return nil, err
+	}
+	if pdetails.phases, err = parsePhases(p, pdetails); err != nil {
+		return nil, err
+	}
+	rpc.wp = newWorkersPool(o, pdetails, log)
 	rpc.protDetails = pdetails
 	rpc.protocol = f
+	rpc.sessionFactory = o.sessionFactory
 	return rpc, nil
 
 }
@@ -92,28 +321,144 @@ func (rpc *RPCServer) Close() {
 	rpc.wp.Close()
 }
 
+// Session returns connected session by ID
+func (rpc *RPCServer) Session(id string) (*RPCConn, bool) {
+	rpc.sessionsLock.RLock()
+	defer rpc.sessionsLock.RUnlock()
+	conn, ok := rpc.sessions[id]
+	return conn, ok
+}
+
+// Sessions returns all connected sessions
+func (rpc *RPCServer) Sessions() []*RPCConn {
+	rpc.sessionsLock.RLock()
+	defer rpc.sessionsLock.RUnlock()
+	ret := make([]*RPCConn, 0, len(rpc.sessions))
+	for _, conn := range rpc.sessions {
+		ret = append(ret, conn)
+	}
+	return ret
+}
+
+// Broadcast sends notification to all connected sessions, notifications
+// rejected by slow consumer policy of sessions are reported in returned error
+func (rpc *RPCServer) Broadcast(notification interface{}) error {
+	return rpc.BroadcastFilter(nil, notification)
+}
+
+// BroadcastFilter sends notification to connected sessions accepted by
+// filter (nil filter accepts all of them). Notification is encoded once
+// per codec used by recipients.
+func (rpc *RPCServer) BroadcastFilter(filter func(conn *RPCConn) bool, notification interface{}) error {
+	conns := rpc.Sessions()
+	if filter != nil {
+		accepted := conns[:0]
+		for _, conn := range conns {
+			if filter(conn) {
+				accepted = append(accepted, conn)
+			}
+		}
+		conns = accepted
+	}
+	return notifySessions(conns, func(codec Codec) (*Packet, error) {
+		return newNotificationPacket(rpc.protDetails, codec, notification)
+	})
+}
+
+// notifySessions sends packet created by newPacket to sessions,
+// packet is created once per codec. It doesn't wait for slow consumers,
+// so one stalled session doesn't delay others.
+func notifySessions(conns []*RPCConn, newPacket func(codec Codec) (*Packet, error)) error {
+	packets := make(map[string]*Packet) // by codec name
+	var rejected []error
+	for _, conn := range conns {
+		p, ok := packets[conn.codec.Name()]
+		if !ok {
+			var err error
+			p, err = newPacket(conn.codec)
+			if err != nil {
+				return err
+			}
+			packets[conn.codec.Name()] = p
+		}
+		if err := conn.sendNotification(p, false); err != nil {
+			rejected = append(rejected, fmt.Errorf("session %s: %w", conn.id, err))
+		}
+	}
+	return errors.Join(rejected...)
+}
+
+func (rpc *RPCServer) addSession(conn *RPCConn) {
+	rpc.sessionsLock.Lock()
+	rpc.sessions[conn.id] = conn
+	rpc.sessionsLock.Unlock()
+}
+
+func (rpc *RPCServer) removeSession(conn *RPCConn) {
+	rpc.sessionsLock.Lock()
+	delete(rpc.sessions, conn.id)
+	rpc.sessionsLock.Unlock()
+}
+
 func (rpc *RPCServer) procConn(tr RPCTransport) {
 	rpc.log.Debugf("new connection established")
-	prot := rpc.protocol()
-	respCh := make(chan *Packet)
-	prot.OnConnect(&RPCConn{rpc.protDetails, respCh, tr})
+	codec, err := selectCodec(rpc.codecs, tr)
+	if err != nil {
+		rpc.log.Errorf("can't select codec for connection: %s", err.Error())
+		tr.Close()
+		return
+	}
+	info := ConnInfo{ConnectedAt: time.Now()}
+	if ip, ok := tr.(ConnInfoProvider); ok {
+		info = ip.ConnInfo()
+	}
+	prot := rpc.newSession(info)
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := &RPCConn{
+		id:          uuid.NewV4().String(),
+		info:        info,
+		protDetails: rpc.protDetails,
+		codec:       codec,
+		out:         newOutboundQueue(rpc.outQueueSize, rpc.slowConsumer),
+		closer:      tr,
+		ctx:         ctx,
+		cancel:      cancel,
+		calls:       make(map[string]context.CancelFunc),
+		flow:        NewFlowController(rpc.callTimeout),
+		streams:     make(map[string]*serverStream),
+		topics:      make(map[string]struct{}),
+	}
+	if a, ok := tr.(Authenticated); ok {
+		conn.identity = a.Identity()
+	}
+	if ps, ok := prot.(PhasedSession); ok {
+		conn.phase = ps.Phases().Initial
+	}
+	// session is available in contexts of its calls
+	conn.ctx = context.WithValue(ctx, connCtxKey{}, conn)
+	rpc.addSession(conn)
+	prot.OnConnect(conn)
 
 	// sender goroutine
 	go func() {
 		for {
-			select {
-			case retPacket := <-respCh:
-				if retPacket == nil {
-					// connection is closed, just finish this goroutine
-					return
-				}
-
+			if retPacket := conn.out.pop(); retPacket != nil {
 				err := tr.Send(retPacket)
 				if err != nil {
 					// logging error
 					rpc.log.Errorf("can't send packet to client: %s", err.Error())
 					return
 				}
+				continue
+			}
+
+			select {
+			case <-conn.out.ready:
+				// new packets are queued
+
+			case <-ctx.Done():
+				// connection is closed, just finish this goroutine
+				return
 
 			case <-rpc.finishCh:
 				tr.Close()
@@ -126,12 +471,148 @@ func (rpc *RPCServer) procConn(tr RPCTransport) {
 		packet, err := tr.Recv()
 		if err != nil {
 			rpc.log.Debugf("returning rpc.procConn() with err: %s", err.Error())
+			cancel()
+			rpc.unsubscribeAll(conn)
+			rpc.removeSession(conn)
+			conn.flow.FailAll(&ConnectionClosedError{err})
+			conn.flow.Close()
 			prot.OnDisconnect(err)
-			respCh <- nil
 			return
 		}
 
-		// proc request in workers pool
-		rpc.wp.Process(job{prot, packet, respCh})
+		switch packet.Header.Type {
+		case PT_REQUEST:
+			if rpc.serveBuiltin(conn, packet) {
+				continue
+			}
+			// proc request in workers pool
+			j := job{ctx: conn.startCall(packet.Id()), prot: prot, conn: conn, packet: packet}
+			if md, ok := rpc.protDetails.methods[packet.Header.Method]; ok && md.isStream() {
+				conn.openStream(j.ctx, packet, md, rpc.streamWindow)
+				j.stream = true
+			}
+			if rpc.orderedSessions || rpc.orderedMethods[packet.Header.Method] {
+				rpc.processOrdered(j)
+			} else {
+				rpc.process(j)
+			}
+
+		case PT_NOTIFICATION:
+			// proc client notification in workers pool, no response is sent
+			j := job{ctx: conn.ctx, prot: prot, conn: conn, packet: packet}
+			if rpc.orderedSessions {
+				rpc.processOrdered(j)
+			} else {
+				rpc.process(j)
+			}
+
+		case PT_CANCEL:
+			if conn.cancelCall(packet.Id()) {
+				rpc.log.Debugf("request %s cancelled by client: %s", packet.Id(), string(packet.Body))
+			}
+
+		case PT_STREAM_ACK:
+			if st := conn.stream(packet.Id()); st != nil {
+				st.credits.add(parseCredits(packet))
+			}
+
+		case PT_STREAM_ITEM:
+			if st := conn.stream(packet.Id()); st != nil && !st.push(packet) {
+				// client ignores credits
+				if conn.cancelCall(packet.Id()) {
+					conn.send(packet.Error(&RemoteError{Code: ErrCodeInvalidRequest, Message: "stream window overflow"}))
+				}
+			}
+
+		case PT_STREAM_END:
+			if st := conn.stream(packet.Id()); st != nil {
+				st.closeRecv()
+			}
+
+		case PT_RESPONSE:
+			if rw := conn.flow.GetWaiter(packet.Id()); rw != nil {
+				rw.setData(packet)
+			}
+
+		case PT_ERROR:
+			if rw := conn.flow.GetWaiter(packet.Id()); rw != nil {
+				rw.setError(parseRemoteError(rpc.protDetails, codec, packet.Body))
+			}
+
+		default:
+			rpc.log.Errorf("[rpc.procConn] unexpected packet type <%s>", printableType(packet.Header.Type))
+		}
+	}
+}
+
+// newSession creates session protocol of new connection
+func (rpc *RPCServer) newSession(info ConnInfo) SessionProtocol {
+	if rpc.sessionFactory != nil {
+		return rpc.sessionFactory(info)
+	}
+	return rpc.protocol()
+}
+
+func (rpc *RPCServer) process(j job) {
+	if j.stream {
+		// stream may live as long as session, so it doesn't hold pool worker
+		if rpc.wp.ProcessStream(j) {
+			return
+		}
+	} else if rpc.wp.Process(j) {
+		return
+	}
+	rpc.reject(j)
+}
+
+func (rpc *RPCServer) reject(j job) {
+	if j.packet.Header.Type == PT_NOTIFICATION {
+		rpc.log.Warningf("client notification %s dropped: %s", j.packet.Header.Method, ErrOverloaded.Message)
+	} else {
+		rpc.log.Warningf("request %s rejected: %s", j.packet.Id(), ErrOverloaded.Message)
+		j.conn.finishCall(j.packet.Id())
+		j.conn.send(j.packet.Error(ErrOverloaded))
+	}
+	if j.done != nil {
+		j.done()
 	}
 }
+
+// processOrdered processes ordered requests of session strictly sequentially,
+// next ordered request is passed to workers pool when previous one is done
+func (rpc *RPCServer) processOrdered(j job) {
+	c := j.conn
+	j.done = func() { rpc.orderedDone(c) }
+
+	c.orderedLock.Lock()
+	if c.orderedBusy {
+		if len(c.orderedQueue) >= rpc.queueSize {
+			c.orderedLock.Unlock()
+			j.done = nil
+			rpc.reject(j)
+			return
+		}
+		c.orderedQueue = append(c.orderedQueue, j)
+		c.orderedLock.Unlock()
+		return
+	}
+	c.orderedBusy = true
+	c.orderedLock.Unlock()
+
+	rpc.process(j)
+}
+
+func (rpc *RPCServer) orderedDone(c *RPCConn) {
+	c.orderedLock.Lock()
+	if len(c.orderedQueue) == 0 {
+		c.orderedBusy = false
+		c.orderedLock.Unlock()
+		return
+	}
+	next := c.orderedQueue[0]
+	c.orderedQueue[0] = job{}
+	c.orderedQueue = c.orderedQueue[1:]
+	c.orderedLock.Unlock()
+
+	rpc.process(next)
+}
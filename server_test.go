@@ -1,8 +1,13 @@
 package wsrpc
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestAbstractRPC(t *testing.T) {
@@ -66,11 +71,30 @@ func TestAbstractRPC(t *testing.T) {
 		t.Error("invalid err type")
 		return
 	}
-	if string(p.Body) != "bad name!" {
+	re := parseRemoteError(srv.protDetails, JSONCodec, p.Body)
+	if re.Code != ErrCodeUnknown || re.Message != "bad name!" {
 		t.Error("invalid err body")
 		return
 	}
 
+	// check declared error
+	req = NewPacket(PT_REQUEST, "MyFind", []byte("{\"name\":\"Eve\"}"))
+	conn.in <- req
+	p = <-conn.out
+	if p.Header.Type != PT_ERROR {
+		t.Error("invalid err type")
+		return
+	}
+	re = parseRemoteError(srv.protDetails, JSONCodec, p.Body)
+	if re.Code != 403 || re.Type != "DeniedError" || re.Message != "find failed: denied: eavesdropper" {
+		t.Error(string(p.Body))
+		return
+	}
+	if de, ok := re.Unwrap().(*DeniedError); !ok || de.Reason != "eavesdropper" {
+		t.Error("declared error expected")
+		return
+	}
+
 	// check fail
 	req = NewPacket(PT_REQUEST, "UnknMethod", []byte("{\"name\":\"Bob\"}"))
 	conn.in <- req
@@ -83,11 +107,22 @@ func TestAbstractRPC(t *testing.T) {
 		t.Error("invalid err type")
 		return
 	}
-	if string(p.Body) != "no method UnknMethod found" {
+	re = parseRemoteError(srv.protDetails, JSONCodec, p.Body)
+	if re.Code != ErrCodeMethodNotFound || re.Message != "no method UnknMethod found" {
 		t.Error("invalid err body")
 		return
 	}
 
+	// check invalid request body
+	req = NewPacket(PT_REQUEST, "MyMethod", []byte("{\"name\":"))
+	conn.in <- req
+	p = <-conn.out
+	re = parseRemoteError(srv.protDetails, JSONCodec, p.Body)
+	if p.Header.Type != PT_ERROR || re.Code != ErrCodeInvalidRequest {
+		t.Error(string(p.Body))
+		return
+	}
+
 	// emit invalid notification from server
 	req = NewPacket(PT_REQUEST, "EmitInvalidNotification", []byte("{\"name\":\"Bob\"}"))
 	conn.in <- req
@@ -96,7 +131,8 @@ func TestAbstractRPC(t *testing.T) {
 		t.Error("invalid err type")
 		return
 	}
-	if string(p.Body) != "Notification *wsrpc.SomeResp is not declared in protocol" {
+	re = parseRemoteError(srv.protDetails, JSONCodec, p.Body)
+	if re.Message != "Notification *wsrpc.SomeResp is not declared in protocol" {
 		t.Error(string(p.Body))
 		return
 	}
@@ -105,6 +141,156 @@ func TestAbstractRPC(t *testing.T) {
 	<-closeCh
 }
 
+func TestSessionContext(t *testing.T) {
+	conns := make(chan RPCTransport)
+	ctxErr := make(chan error, 1)
+
+	srv, err := NewRPCServer(conns, func() SessionProtocol { return &MyProtocol{ctxErr: ctxErr} }, &DummyLogger{LL_INFO})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+
+	conn := NewFakeConn()
+	conns <- conn
+	<-conn.out // hello notification
+
+	if !srv.protDetails.methods["MyWait"].withCtx {
+		t.Fatal("MyWait must be parsed as context-aware method")
+	}
+
+	conn.in <- NewPacket(PT_REQUEST, "MyWait", []byte("{\"name\":\"Bob\"}"))
+	time.Sleep(100 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case err = <-ctxErr:
+		if err != context.Canceled {
+			t.Fatalf("unexpected ctx error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler context is not cancelled on disconnect")
+	}
+}
+
+func TestRequestCancellation(t *testing.T) {
+	conns := make(chan RPCTransport)
+	ctxErr := make(chan error, 1)
+
+	srv, err := NewRPCServer(conns, func() SessionProtocol { return &MyProtocol{ctxErr: ctxErr} }, &DummyLogger{LL_INFO})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+
+	conn := NewFakeConn()
+	conns <- conn
+	<-conn.out // hello notification
+
+	// cancel running handler
+	req := NewPacket(PT_REQUEST, "MyWait", []byte("{\"name\":\"Bob\"}"))
+	conn.in <- req
+	time.Sleep(100 * time.Millisecond)
+	conn.in <- req.Cancel(context.Canceled)
+	select {
+	case err = <-ctxErr:
+		if err != context.Canceled {
+			t.Fatalf("unexpected ctx error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler context is not cancelled")
+	}
+	select {
+	case p := <-conn.out:
+		t.Fatalf("response of cancelled request must be suppressed, got %s", p)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// cancel of unknown or completed request is ignored
+	conn.in <- NewPacket(PT_CANCEL, "MyMethod", []byte("timeout"))
+
+	// race between cancel and completion
+	sent := make(map[string]bool)
+	last := NewPacket(PT_REQUEST, "MyMethod", []byte("{\"name\":\"Bob\"}"))
+	done := make(chan map[string]int)
+	go func() {
+		recvd := make(map[string]int)
+		for p := range conn.out {
+			recvd[p.Id()]++
+			if p.Id() == last.Id() {
+				done <- recvd
+				return
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		req := NewPacket(PT_REQUEST, "MyMethod", []byte("{\"name\":\"Bob\"}"))
+		sent[req.Id()] = true
+		conn.in <- req
+		conn.in <- req.Cancel(context.Canceled)
+	}
+	conn.in <- last
+
+	recvd := <-done
+	for id, cnt := range recvd {
+		if id != last.Id() && !sent[id] {
+			t.Fatalf("unexpected response %s", id)
+		}
+		if cnt != 1 {
+			t.Fatalf("%d responses for request %s", cnt, id)
+		}
+	}
+	conn.Close()
+}
+
+func TestOrderedExecution(t *testing.T) {
+	check := func(ordered bool, opts ...Option) {
+		conns := make(chan RPCTransport)
+		prot := &MyProtocol{}
+		srv, err := NewRPCServer(conns, func() SessionProtocol { return prot }, &DummyLogger{LL_ERROR}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go srv.Run()
+		defer srv.Close()
+
+		conn := NewFakeConn()
+		conns <- conn
+		<-conn.out // hello notification
+
+		names := []string{"slow1", "fast1", "slow2", "fast2", "fast3"}
+		ids := []string{}
+		for _, n := range names {
+			req := NewPacket(PT_REQUEST, "MyAppend", []byte("{\"name\":\""+n+"\"}"))
+			ids = append(ids, req.Id())
+			conn.in <- req
+		}
+		respIds := []string{}
+		for range names {
+			respIds = append(respIds, (<-conn.out).Id())
+		}
+
+		inOrder := strings.Join(respIds, ",") == strings.Join(ids, ",")
+		journal := strings.Join(prot.journal, ",")
+		if ordered && (!inOrder || journal != strings.Join(names, ",")) {
+			t.Fatalf("ordered execution expected, journal: %s", journal)
+		}
+		if !ordered && (inOrder || journal == strings.Join(names, ",")) {
+			t.Fatalf("concurrent execution expected, journal: %s", journal)
+		}
+	}
+
+	check(false)
+	check(true, WithOrderedSessions())
+	check(true, WithOrderedMethods("MyAppend"))
+	check(false, WithOrderedMethods("MyMethod"))
+
+	_, err := NewRPCServer(nil, func() SessionProtocol { return &MyProtocol{} }, &DummyLogger{}, WithOrderedMethods("Unknown"))
+	if err == nil || err.Error() != "unknown ordered method Unknown" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 func BenchmarkAbstractRPCServer(b *testing.B) {
 	conns := make(chan RPCTransport)
 
@@ -123,3 +309,220 @@ func BenchmarkAbstractRPCServer(b *testing.B) {
 		<-conn.out
 	}
 }
+
+func TestServerToClientCall(t *testing.T) {
+	sessions := make(chan *MyProtocol, 1)
+	conns := make(chan RPCTransport)
+	srv, err := NewRPCServer(conns, func() SessionProtocol {
+		p := &MyProtocol{closed: make(chan bool, 1)}
+		sessions <- p
+		return p
+	}, &DummyLogger{}, WithCallTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-sessions // protocol instance of NewRPCServer
+	go srv.Run()
+	defer srv.Close()
+
+	conn := NewFakeConn()
+	conns <- conn
+	<-conn.out // hello notification
+	sess := (<-sessions).conn
+
+	type result struct {
+		resp interface{}
+		err  error
+	}
+	call := func(ctx context.Context, method string, req interface{}) chan result {
+		ch := make(chan result, 1)
+		go func() {
+			resp, err := sess.Call(ctx, method, req)
+			ch <- result{resp, err}
+		}()
+		return ch
+	}
+
+	// response
+	ch := call(context.Background(), "MyMethod", &SomeReq{"Bob"})
+	req := <-conn.out
+	if req.Header.Type != PT_REQUEST || req.Header.Method != "MyMethod" || string(req.Body) != "{\"Name\":\"Bob\"}" {
+		t.Fatalf("unexpected request %s", req)
+	}
+	resp := NewPacket(PT_RESPONSE, "MyMethod", []byte("{\"IsBob\":true}"))
+	resp.Header.MessageId = req.Header.MessageId
+	conn.in <- resp
+	if r := <-ch; r.err != nil || !r.resp.(*SomeResp).IsBob {
+		t.Fatalf("unexpected result: %v, %v", r.resp, r.err)
+	}
+
+	// declared error
+	ch = call(context.Background(), "MyFind", &SomeReq{"Alice"})
+	req = <-conn.out
+	conn.in <- req.Error(newRemoteError(srv.protDetails, JSONCodec, ErrCodeUnknown, &NotFoundError{"Alice"}))
+	var nf *NotFoundError
+	if r := <-ch; !errors.As(r.err, &nf) || nf.Name != "Alice" {
+		t.Fatalf("declared error expected, got %v", r.err)
+	}
+
+	// timeout and cancellation are propagated to client
+	ch = call(context.Background(), "MyWait", &SomeReq{"Bob"})
+	req = <-conn.out
+	if r := <-ch; r.err != TimeoutError {
+		t.Fatalf("timeout expected, got %v", r.err)
+	}
+	if p := <-conn.out; p.Header.Type != PT_CANCEL || p.Id() != req.Id() {
+		t.Fatalf("cancel packet expected, got %s", p)
+	}
+
+	// invalid calls
+	if _, err := sess.Call(context.Background(), "Unknown", &SomeReq{}); err == nil || err.Error() != "unknown method Unknown" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := sess.Call(context.Background(), "MyMethod", &SomeResp{}); err == nil || err.Error() != "invalid request type, *SomeReq expected" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// pending calls fail on disconnect
+	ch = call(context.Background(), "MyWait", &SomeReq{"Bob"})
+	<-conn.out
+	conn.Close()
+	if r := <-ch; !errors.Is(r.err, ClosedConnError) {
+		t.Fatalf("connection closed error expected, got %v", r.err)
+	}
+	if _, err := sess.Call(context.Background(), "MyMethod", &SomeReq{}); !errors.Is(err, ClosedConnError) {
+		t.Fatalf("connection closed error expected, got %v", err)
+	}
+}
+
+func TestClientNotifications(t *testing.T) {
+	typing := make(chan string, 1)
+	panics := make(chan string, 1)
+	onPanic := func(conn *RPCConn, method string, recovered interface{}, stack []byte) {
+		panics <- method
+	}
+	conns := make(chan RPCTransport)
+	srv, err := NewRPCServer(conns, func() SessionProtocol { return &MyProtocol{typing: typing} }, &DummyLogger{}, WithPanicHandler(onPanic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+
+	conn := NewFakeConn()
+	conns <- conn
+	<-conn.out // hello notification
+
+	conn.in <- NewPacket(PT_NOTIFICATION, "TypingNotif", []byte("{\"User\":\"bob\"}"))
+	if user := <-typing; user != "bob" {
+		t.Fatalf("unexpected notification from %s", user)
+	}
+
+	conn.in <- NewPacket(PT_NOTIFICATION, "TypingNotif", []byte("{\"User\":\"panic\"}"))
+	if method := <-panics; method != "OnTypingNotif" {
+		t.Fatalf("unexpected panic in %s", method)
+	}
+	conn.in <- NewPacket(PT_NOTIFICATION, "UnknownNotif", []byte("{}"))
+	conn.in <- NewPacket(PT_NOTIFICATION, "TypingNotif", []byte("invalid"))
+
+	// no responses are sent for notifications
+	req := conn.simulateReq()
+	p := <-conn.out
+	if p.Header.Type != PT_RESPONSE || p.Id() != req.Id() {
+		t.Fatalf("response of request expected, got %s", p)
+	}
+}
+
+// countingCodec counts marshalled bodies
+type countingCodec struct {
+	Codec
+	n *int32
+}
+
+func (c countingCodec) Marshal(v interface{}) ([]byte, error) {
+	atomic.AddInt32(c.n, 1)
+	return c.Codec.Marshal(v)
+}
+
+// negotiatedConn is fake transport with negotiated codec
+type negotiatedConn struct {
+	*FakeConn
+	codec string
+}
+
+func (c negotiatedConn) CodecName() string { return c.codec }
+
+func TestSessionRegistry(t *testing.T) {
+	var marshalled int32
+	closed := make(chan bool, 3)
+	conns := make(chan RPCTransport)
+	srv, err := NewRPCServer(conns, func() SessionProtocol { return &MyProtocol{closed: closed} }, &DummyLogger{},
+		WithCodecs(countingCodec{JSONCodec, &marshalled}, countingCodec{MsgpackCodec, &marshalled}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+
+	fakes := []*FakeConn{NewFakeConn(), NewFakeConn(), NewFakeConn()}
+	for i, codec := range []string{"json", "json", "msgpack"} {
+		conns <- negotiatedConn{fakes[i], codec}
+		<-fakes[i].out // hello notification
+	}
+
+	sessions := srv.Sessions()
+	if len(sessions) != 3 {
+		t.Fatalf("3 sessions expected, got %d", len(sessions))
+	}
+	for _, s := range sessions {
+		if conn, ok := srv.Session(s.ID()); !ok || conn != s {
+			t.Fatalf("session %s is not found", s.ID())
+		}
+	}
+	if _, ok := srv.Session("unknown"); ok {
+		t.Fatal("unknown session found")
+	}
+
+	// notification is encoded once per codec
+	atomic.StoreInt32(&marshalled, 0)
+	if err := srv.Broadcast(&MyNotif{"to all"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, fc := range fakes {
+		if p := <-fc.out; p.Header.Type != PT_NOTIFICATION || p.Header.Method != "MyNotif" {
+			t.Fatalf("notification expected, got %s", p)
+		}
+	}
+	if n := atomic.LoadInt32(&marshalled); n != 2 {
+		t.Fatalf("notification must be encoded 2 times, got %d", n)
+	}
+
+	if err := srv.Broadcast(&SomeResp{}); err == nil || !strings.Contains(err.Error(), "is not declared in protocol") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// filtered broadcast
+	target := sessions[0].ID()
+	err = srv.BroadcastFilter(func(conn *RPCConn) bool { return conn.ID() == target }, &MyNotif{"to one"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := 0
+	for _, fc := range fakes {
+		select {
+		case <-fc.out:
+			received++
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	if received != 1 {
+		t.Fatalf("1 notification expected, got %d", received)
+	}
+
+	// disconnected session is removed
+	fakes[0].Close()
+	<-closed
+	if len(srv.Sessions()) != 2 {
+		t.Fatalf("2 sessions expected after disconnect, got %d", len(srv.Sessions()))
+	}
+}
@@ -0,0 +1,655 @@
+package wsrpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"iter"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// Stream sends items of server streaming method to client.
+// Send blocks while client has no free room for items (backpressure)
+// and fails when client cancels the call or disconnects.
+//
+//	func (p *P) Watch(ctx context.Context, req *WatchReq, s wsrpc.Stream[*Event]) error
+type Stream[T any] struct {
+	s *serverStream
+}
+
+// Send sends item to client
+func (s Stream[T]) Send(item T) error {
+	return s.s.send(item)
+}
+
+// Context returns context of streaming call
+func (s Stream[T]) Context() context.Context {
+	return s.s.ctx
+}
+
+func (Stream[T]) streamTypes() (recv, send reflect.Type) {
+	return nil, typeOf[T]()
+}
+
+func (Stream[T]) withState(s *serverStream) interface{} {
+	return Stream[T]{s}
+}
+
+// RecvStream receives items of client streaming method from client.
+// Recv returns io.EOF when client finishes sending, client can't send
+// more items than handler has consumed plus stream window.
+//
+//	func (p *P) Upload(ctx context.Context, req *UploadReq, s wsrpc.RecvStream[*Chunk]) (*UploadResp, error)
+type RecvStream[T any] struct {
+	s *serverStream
+}
+
+// Recv returns next item of client
+func (s RecvStream[T]) Recv() (T, error) {
+	return recvItem[T](s.s)
+}
+
+// Context returns context of streaming call
+func (s RecvStream[T]) Context() context.Context {
+	return s.s.ctx
+}
+
+func (RecvStream[T]) streamTypes() (recv, send reflect.Type) {
+	return typeOf[T](), nil
+}
+
+func (RecvStream[T]) withState(s *serverStream) interface{} {
+	return RecvStream[T]{s}
+}
+
+// BidiStream receives items of bidirectional streaming method from client
+// and sends items to client, both directions are flow controlled.
+//
+//	func (p *P) Chat(ctx context.Context, req *JoinReq, s wsrpc.BidiStream[*Msg, *Msg]) error
+type BidiStream[In, Out any] struct {
+	s *serverStream
+}
+
+// Send sends item to client
+func (s BidiStream[In, Out]) Send(item Out) error {
+	return s.s.send(item)
+}
+
+// Recv returns next item of client, io.EOF is returned when client finishes sending
+func (s BidiStream[In, Out]) Recv() (In, error) {
+	return recvItem[In](s.s)
+}
+
+// Context returns context of streaming call
+func (s BidiStream[In, Out]) Context() context.Context {
+	return s.s.ctx
+}
+
+func (BidiStream[In, Out]) streamTypes() (recv, send reflect.Type) {
+	return typeOf[In](), typeOf[Out]()
+}
+
+func (BidiStream[In, Out]) withState(s *serverStream) interface{} {
+	return BidiStream[In, Out]{s}
+}
+
+// streamParam is implemented by stream argument types, it allows to
+// recognize streaming methods and to create their stream argument
+type streamParam interface {
+	streamTypes() (recv, send reflect.Type) // nil for unused direction
+	withState(s *serverStream) interface{}
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// credits limits number of items sender can send,
+// receiver grants new credits when it consumes items
+type credits struct {
+	mu sync.Mutex
+	n  int
+	ch chan struct{} // signals new credits
+}
+
+func newCredits() *credits {
+	return &credits{ch: make(chan struct{}, 1)}
+}
+
+func (c *credits) add(n int) {
+	c.mu.Lock()
+	c.n += n
+	c.mu.Unlock()
+	select {
+	case c.ch <- struct{}{}:
+	default:
+	}
+}
+
+// acquire waits for credit to send one item until ctx or done is closed
+func (c *credits) acquire(ctx context.Context, done <-chan struct{}) error {
+	for {
+		c.mu.Lock()
+		if c.n > 0 {
+			c.n--
+			c.mu.Unlock()
+			return nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done:
+			return io.EOF
+		}
+	}
+}
+
+// parseCredits returns number of credits granted by PT_STREAM_ACK packet
+func parseCredits(p *Packet) int {
+	n, err := strconv.Atoi(string(p.Body))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// inbox buffers received stream items, consumed items are
+// acknowledged to sender to grant it new credits.
+// Items must be consumed by single goroutine.
+type inbox struct {
+	items    chan *Packet
+	window   int
+	consumed int // items consumed since last credits grant
+	ack      func(n int)
+}
+
+func newInbox(window int, ack func(n int)) *inbox {
+	return &inbox{items: make(chan *Packet, window), window: window, ack: ack}
+}
+
+// push buffers received item, false is returned if sender ignores credits
+func (in *inbox) push(p *Packet) bool {
+	select {
+	case in.items <- p:
+		return true
+	default:
+		return false
+	}
+}
+
+// pop returns next item, nil is returned when done is closed and
+// all items received before are consumed
+func (in *inbox) pop(ctx context.Context, done <-chan struct{}) (*Packet, error) {
+	if err := ctx.Err(); err != nil {
+		// items of cancelled stream are dropped
+		return nil, err
+	}
+	select {
+	case p := <-in.items:
+		return in.take(p), nil
+	default:
+	}
+
+	select {
+	case p := <-in.items:
+		return in.take(p), nil
+	case <-done:
+		// items received before end of stream go first
+		select {
+		case p := <-in.items:
+			return in.take(p), nil
+		default:
+		}
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (in *inbox) take(p *Packet) *Packet {
+	in.consumed++
+	if in.consumed >= (in.window+1)/2 {
+		// grant credits for consumed items
+		in.ack(in.consumed)
+		in.consumed = 0
+	}
+	return p
+}
+
+// serverStream holds state of streaming call on server side
+type serverStream struct {
+	ctx    context.Context
+	conn   *RPCConn
+	packet *Packet // request packet
+
+	credits *credits // granted by client for items sent to it
+
+	in       *inbox // items of client, nil if method doesn't receive them
+	recvOnce sync.Once
+	recvDone chan struct{} // closed when client finishes sending
+}
+
+func newServerStream(ctx context.Context, conn *RPCConn, packet *Packet, md methodDetails, window int) *serverStream {
+	s := &serverStream{ctx: ctx, conn: conn, packet: packet, credits: newCredits(), recvDone: make(chan struct{})}
+	if md.recvType != nil {
+		s.in = newInbox(window, s.grant)
+	}
+	return s
+}
+
+// grant grants n credits to client
+func (s *serverStream) grant(n int) {
+	s.conn.send(s.packet.reply(PT_STREAM_ACK, []byte(strconv.Itoa(n))))
+}
+
+// grantWindow allows client to send the whole window of items
+func (s *serverStream) grantWindow() {
+	s.grant(s.in.window)
+}
+
+// push passes item of client to stream, false is returned on overflow
+func (s *serverStream) push(p *Packet) bool {
+	if s.in == nil {
+		return true
+	}
+	return s.in.push(p)
+}
+
+// closeRecv marks end of client items
+func (s *serverStream) closeRecv() {
+	s.recvOnce.Do(func() { close(s.recvDone) })
+}
+
+func recvItem[T any](s *serverStream) (T, error) {
+	var item T
+	p, err := s.in.pop(s.ctx, s.recvDone)
+	if err != nil {
+		return item, err
+	}
+	if p == nil {
+		return item, io.EOF
+	}
+	itemV := reflect.New(typeOf[T]().Elem())
+	if err := s.conn.codec.Unmarshal(p.Body, itemV.Interface()); err != nil {
+		return item, err
+	}
+	return itemV.Interface().(T), nil
+}
+
+func (s *serverStream) send(item interface{}) error {
+	if err := s.credits.acquire(s.ctx, nil); err != nil {
+		return err
+	}
+	buf, err := s.conn.codec.Marshal(item)
+	if err != nil {
+		return err
+	}
+	s.conn.send(s.packet.reply(PT_STREAM_ITEM, buf))
+	return s.ctx.Err()
+}
+
+// sendFrom sends items received from channel ch until it is closed
+func (s *serverStream) sendFrom(ch reflect.Value) error {
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: ch},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.ctx.Done())},
+	}
+	for {
+		chosen, item, ok := reflect.Select(cases)
+		if chosen == 1 {
+			return s.ctx.Err()
+		}
+		if !ok {
+			return nil
+		}
+		if err := s.send(item.Interface()); err != nil {
+			return err
+		}
+	}
+}
+
+// ClientStream is client side of streaming call. Items of server are
+// received with Recv, items of client are sent with Send and CloseSend.
+// Recv must not be called concurrently, the same is true for Send.
+type ClientStream struct {
+	cli    *RPCClient
+	ctx    context.Context
+	md     methodDetails
+	packet *Packet // request packet
+
+	in       *inbox   // items of server
+	credits  *credits // granted by server for items sent to it
+	sendOnce sync.Once
+
+	once sync.Once
+	done chan struct{}
+	resp *Packet // response of client streaming method
+	err  error
+
+	stopLock sync.Mutex
+	stop     func() bool // stops cancellation of call by ctx
+}
+
+// Stream calls streaming method. Items of server streaming and bidirectional
+// methods are received with ClientStream.Recv until io.EOF is returned,
+// items of client streaming and bidirectional methods are sent with
+// ClientStream.Send. Cancellation of ctx or ClientStream.Close cancels
+// the call on server.
+func (cli *RPCClient) Stream(ctx context.Context, method string, request interface{}) (*ClientStream, error) {
+	if cli.Closed() {
+		return nil, cli.closeErr
+	}
+	md, ok := cli.protDetails.methods[method]
+	if !ok {
+		return nil, fmt.Errorf("unknown method %s", method)
+	}
+	if !md.isStream() {
+		return nil, fmt.Errorf("method %s is not streaming", method)
+	}
+	if request == nil || reflect.TypeOf(request) != reflect.PtrTo(md.inType) {
+		return nil, fmt.Errorf("invalid request type, *%s expected", md.inType.Name())
+	}
+
+	reqBody, err := cli.codec.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	cs := &ClientStream{
+		cli:     cli,
+		ctx:     ctx,
+		md:      md,
+		packet:  NewPacket(PT_REQUEST, method, reqBody),
+		credits: newCredits(),
+		done:    make(chan struct{}),
+	}
+	cs.in = newInbox(cli.streamWindow, cs.grant)
+
+	cli.streamsLock.Lock()
+	cli.streams[cs.packet.Id()] = cs
+	cli.streamsLock.Unlock()
+
+	if err := cli.send(cs.packet); err != nil {
+		cs.finish(nil, err)
+		return nil, err
+	}
+	if md.streaming {
+		if err := cli.send(cs.packet.reply(PT_STREAM_ACK, []byte(strconv.Itoa(cs.in.window)))); err != nil {
+			cs.finish(nil, err)
+			return nil, err
+		}
+	}
+	// call on server is cancelled with ctx even if stream isn't read
+	cs.stopLock.Lock()
+	select {
+	case <-cs.done:
+	default:
+		cs.stop = context.AfterFunc(ctx, func() { cs.cancel(ctx.Err()) })
+	}
+	cs.stopLock.Unlock()
+	return cs, nil
+}
+
+// Recv returns next item of stream, io.EOF is returned when stream ends
+func (cs *ClientStream) Recv() (interface{}, error) {
+	p, err := cs.in.pop(cs.ctx, cs.done)
+	if err != nil {
+		cs.cancel(err)
+		return nil, err
+	}
+	if p == nil {
+		return nil, cs.doneErr()
+	}
+
+	outV := reflect.New(cs.md.outType)
+	if err := cs.cli.codec.Unmarshal(p.Body, outV.Interface()); err != nil {
+		return nil, err
+	}
+	return outV.Interface(), nil
+}
+
+// Send sends item to server, it blocks while server has no free room for items.
+// io.EOF is returned if stream is finished by server without error.
+func (cs *ClientStream) Send(item interface{}) error {
+	if cs.md.recvType == nil {
+		return fmt.Errorf("method %s doesn't receive stream items", cs.packet.Header.Method)
+	}
+	if item == nil || reflect.TypeOf(item) != reflect.PtrTo(cs.md.recvType) {
+		return fmt.Errorf("invalid item type, *%s expected", cs.md.recvType.Name())
+	}
+	if err := cs.credits.acquire(cs.ctx, cs.done); err == io.EOF {
+		return cs.doneErr()
+	} else if err != nil {
+		cs.cancel(err)
+		return err
+	}
+
+	buf, err := cs.cli.codec.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return cs.cli.send(cs.packet.reply(PT_STREAM_ITEM, buf))
+}
+
+// CloseSend notifies server that client finished sending items
+func (cs *ClientStream) CloseSend() error {
+	var err error
+	cs.sendOnce.Do(func() {
+		// packet body can't be empty
+		err = cs.cli.send(cs.packet.reply(PT_STREAM_END, []byte("end")))
+	})
+	return err
+}
+
+// CloseAndRecv finishes sending items of client streaming method
+// and waits for response of server
+func (cs *ClientStream) CloseAndRecv() (interface{}, error) {
+	if cs.md.streaming {
+		return nil, fmt.Errorf("method %s streams items, use Recv", cs.packet.Header.Method)
+	}
+	if err := cs.CloseSend(); err != nil {
+		cs.cancel(err)
+		return nil, err
+	}
+
+	select {
+	case <-cs.done:
+	case <-cs.ctx.Done():
+		cs.cancel(cs.ctx.Err())
+		return nil, cs.ctx.Err()
+	}
+	if cs.err != nil {
+		return nil, cs.err
+	}
+	outV := reflect.New(cs.md.outType)
+	if err := cs.cli.codec.Unmarshal(cs.resp.Body, outV.Interface()); err != nil {
+		return nil, err
+	}
+	return outV.Interface(), nil
+}
+
+// Close cancels stream if it is not finished yet
+func (cs *ClientStream) Close() error {
+	cs.cancel(context.Canceled)
+	return nil
+}
+
+// grant grants n credits to server
+func (cs *ClientStream) grant(n int) {
+	if err := cs.cli.send(cs.packet.reply(PT_STREAM_ACK, []byte(strconv.Itoa(n)))); err != nil {
+		cs.cli.log.Debugf("can't send stream ack: %s", err.Error())
+	}
+}
+
+// doneErr returns error of finished stream, io.EOF if stream ends normally
+func (cs *ClientStream) doneErr() error {
+	if cs.err == nil {
+		return io.EOF
+	}
+	return cs.err
+}
+
+// push passes received item to stream
+func (cs *ClientStream) push(p *Packet) {
+	if !cs.in.push(p) {
+		// server ignores credits
+		cs.cancel(fmt.Errorf("stream window overflow"))
+	}
+}
+
+// cancel finishes stream with err and asks server to stop it
+func (cs *ClientStream) cancel(err error) {
+	if cs.finish(nil, err) {
+		if err := cs.cli.send(cs.packet.Cancel(err)); err != nil {
+			cs.cli.log.Debugf("can't send cancel packet: %s", err.Error())
+		}
+	}
+}
+
+// finish completes stream with response resp of client streaming method
+// or err, false is returned if stream is already finished
+func (cs *ClientStream) finish(resp *Packet, err error) bool {
+	finished := false
+	cs.once.Do(func() {
+		cs.resp = resp
+		cs.err = err
+		cs.stopLock.Lock()
+		close(cs.done)
+		stop := cs.stop
+		cs.stopLock.Unlock()
+		if stop != nil {
+			stop()
+		}
+		finished = true
+
+		cs.cli.streamsLock.Lock()
+		delete(cs.cli.streams, cs.packet.Id())
+		cs.cli.streamsLock.Unlock()
+	})
+	return finished
+}
+
+// stream returns running stream by message id
+func (cli *RPCClient) stream(mid string) *ClientStream {
+	cli.streamsLock.Lock()
+	defer cli.streamsLock.Unlock()
+	return cli.streams[mid]
+}
+
+// failStreams finishes all running streams with err
+func (cli *RPCClient) failStreams(err error) {
+	cli.streamsLock.Lock()
+	streams := make([]*ClientStream, 0, len(cli.streams))
+	for _, cs := range cli.streams {
+		streams = append(streams, cs)
+	}
+	cli.streamsLock.Unlock()
+
+	for _, cs := range streams {
+		cs.finish(nil, err)
+	}
+}
+
+// InvokeStream calls server streaming method and returns iterator over
+// its items. Breaking the loop cancels the stream.
+//
+//	for ev, err := range wsrpc.InvokeStream[WatchReq, Event](ctx, cli, "Watch", req) {
+//		...
+//	}
+func InvokeStream[Req, Item any](ctx context.Context, cli *RPCClient, method string, req *Req) iter.Seq2[*Item, error] {
+	return func(yield func(*Item, error) bool) {
+		md, ok := cli.protDetails.methods[method]
+		if ok && md.recvType != nil {
+			yield(nil, fmt.Errorf("method %s receives stream items, use OpenStream", method))
+			return
+		}
+		if ok && md.outType != typeOf[Item]() {
+			yield(nil, fmt.Errorf("invalid item type, *%s expected", md.outType.Name()))
+			return
+		}
+
+		cs, err := cli.Stream(ctx, method, req)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer cs.Close()
+
+		for {
+			item, err := cs.Recv()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(item.(*Item), nil) {
+				return
+			}
+		}
+	}
+}
+
+// TypedStream is ClientStream of client streaming or bidirectional
+// method with typed items, Out is response type of client streaming
+// method or item type of bidirectional one
+type TypedStream[In, Out any] struct {
+	*ClientStream
+}
+
+// Send sends item to server
+func (s *TypedStream[In, Out]) Send(item *In) error {
+	return s.ClientStream.Send(item)
+}
+
+// Recv returns next item of bidirectional stream
+func (s *TypedStream[In, Out]) Recv() (*Out, error) {
+	item, err := s.ClientStream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return item.(*Out), nil
+}
+
+// CloseAndRecv finishes sending items of client streaming method
+// and waits for its response
+func (s *TypedStream[In, Out]) CloseAndRecv() (*Out, error) {
+	resp, err := s.ClientStream.CloseAndRecv()
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*Out), nil
+}
+
+// OpenStream calls client streaming or bidirectional method:
+//
+//	up, err := wsrpc.OpenStream[UploadReq, Chunk, UploadResp](ctx, cli, "Upload", req)
+//	err = up.Send(&Chunk{...})
+//	resp, err := up.CloseAndRecv()
+func OpenStream[Req, In, Out any](ctx context.Context, cli *RPCClient, method string, req *Req) (*TypedStream[In, Out], error) {
+	if md, ok := cli.protDetails.methods[method]; ok {
+		if md.recvType == nil {
+			return nil, fmt.Errorf("method %s doesn't receive stream items", method)
+		}
+		if md.recvType != typeOf[In]() {
+			return nil, fmt.Errorf("invalid item type, *%s expected", md.recvType.Name())
+		}
+		if md.outType != typeOf[Out]() && md.streaming {
+			return nil, fmt.Errorf("invalid item type, *%s expected", md.outType.Name())
+		}
+		if md.outType != typeOf[Out]() {
+			return nil, fmt.Errorf("invalid response type, *%s expected", md.outType.Name())
+		}
+	}
+
+	cs, err := cli.Stream(ctx, method, req)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedStream[In, Out]{cs}, nil
+}
@@ -0,0 +1,439 @@
+package wsrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func expectNoPacket(t *testing.T, conn *FakeConn) {
+	t.Helper()
+	select {
+	case p := <-conn.out:
+		t.Fatalf("unexpected packet %s", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	ctxErr := make(chan error, 1)
+	conns := make(chan RPCTransport)
+	srv, err := NewRPCServer(conns, func() SessionProtocol { return &MyProtocol{ctxErr: ctxErr} }, &DummyLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+
+	conn := NewFakeConn()
+	conns <- conn
+	<-conn.out // hello notification
+
+	// items are sent only for granted credits
+	req := NewPacket(PT_REQUEST, "MyCount", []byte("{\"name\":\"5\"}"))
+	conn.in <- req
+	expectNoPacket(t, conn)
+
+	conn.in <- req.reply(PT_STREAM_ACK, []byte("3"))
+	for i := 1; i <= 3; i++ {
+		p := <-conn.out
+		if p.Header.Type != PT_STREAM_ITEM || p.Id() != req.Id() || string(p.Body) != "{\"N\":"+string(rune('0'+i))+"}" {
+			t.Fatalf("item #%d expected, got %s", i, p)
+		}
+	}
+	expectNoPacket(t, conn)
+
+	conn.in <- req.reply(PT_STREAM_ACK, []byte("10"))
+	for _, tp := range []uint8{PT_STREAM_ITEM, PT_STREAM_ITEM, PT_STREAM_END} {
+		if p := <-conn.out; p.Header.Type != tp || p.Id() != req.Id() {
+			t.Fatalf("%s packet expected, got %s", printableType(tp), p)
+		}
+	}
+
+	// channel based stream
+	req = NewPacket(PT_REQUEST, "MyTicks", []byte("{\"name\":\"2\"}"))
+	conn.in <- req
+	conn.in <- req.reply(PT_STREAM_ACK, []byte("10"))
+	for _, tp := range []uint8{PT_STREAM_ITEM, PT_STREAM_ITEM, PT_STREAM_END} {
+		if p := <-conn.out; p.Header.Type != tp || p.Id() != req.Id() {
+			t.Fatalf("%s packet expected, got %s", printableType(tp), p)
+		}
+	}
+
+	// stream finished with error
+	req = NewPacket(PT_REQUEST, "MyCount", []byte("{\"name\":\"fail\"}"))
+	conn.in <- req
+	conn.in <- req.reply(PT_STREAM_ACK, []byte("10"))
+	<-conn.out
+	<-conn.out
+	p := <-conn.out
+	re := parseRemoteError(srv.protDetails, JSONCodec, p.Body)
+	if p.Header.Type != PT_ERROR || re.Type != "NotFoundError" {
+		t.Fatalf("declared error expected, got %s", p)
+	}
+
+	// cancellation of blocked stream
+	req = NewPacket(PT_REQUEST, "MyCount", []byte("{\"name\":\"100\"}"))
+	conn.in <- req
+	conn.in <- req.reply(PT_STREAM_ACK, []byte("1"))
+	<-conn.out
+	conn.in <- req.Cancel(context.Canceled)
+	if err := <-ctxErr; err != context.Canceled {
+		t.Fatalf("cancelled stream expected, got %v", err)
+	}
+	expectNoPacket(t, conn)
+}
+
+func TestClientStream(t *testing.T) {
+	ctxErr := make(chan error, 1)
+	closech := make(chan struct{})
+	go ServeWSRPC(func() SessionProtocol { return &MyProtocol{ctxErr: ctxErr} }, ":8090", "/test/wsrpc", &DummyLogger{}, closech)
+	time.Sleep(100 * time.Millisecond)
+	defer close(closech)
+
+	cli, err := ClientWSRPC(&MyProtocol{}, "ws://127.0.0.1:8090/test/wsrpc", time.Second, nil, &DummyLogger{}, WithStreamWindow(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	// all items with small window
+	n := 0
+	for item, err := range InvokeStream[SomeReq, CountItem](context.Background(), cli, "MyCount", &SomeReq{"50"}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+		if item.N != n {
+			t.Fatalf("item %d expected, got %d", n, item.N)
+		}
+	}
+	if n != 50 {
+		t.Fatalf("50 items expected, got %d", n)
+	}
+
+	// channel based stream
+	cs, err := cli.Stream(context.Background(), "MyTicks", &SomeReq{"3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		item, err := cs.Recv()
+		if err != nil || item.(*CountItem).N != i {
+			t.Fatalf("item %d expected, got %v, %v", i, item, err)
+		}
+	}
+	if _, err := cs.Recv(); err != io.EOF {
+		t.Fatalf("end of stream expected, got %v", err)
+	}
+
+	// breaking loop cancels stream on server
+	for item := range InvokeStream[SomeReq, CountItem](context.Background(), cli, "MyCount", &SomeReq{"1000"}) {
+		if item.N == 2 {
+			break
+		}
+	}
+	select {
+	case err := <-ctxErr:
+		if err != context.Canceled {
+			t.Fatalf("cancelled stream expected, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stream is not cancelled on server")
+	}
+
+	// context cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	cs, err = cli.Stream(ctx, "MyCount", &SomeReq{"1000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.Recv()
+	cancel()
+	for err == nil {
+		_, err = cs.Recv()
+	}
+	if err != context.Canceled {
+		t.Fatalf("cancelled stream expected, got %v", err)
+	}
+	<-ctxErr
+
+	// context cancellation of stream which isn't read
+	ctx, cancel = context.WithCancel(context.Background())
+	cs, err = cli.Stream(ctx, "MyCount", &SomeReq{"1000000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.Recv()
+	cancel()
+	select {
+	case err := <-ctxErr:
+		if err != context.Canceled {
+			t.Fatalf("cancelled stream expected, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stream is not cancelled on server")
+	}
+	if _, err := cs.Recv(); err != context.Canceled {
+		t.Fatalf("cancelled stream expected, got %v", err)
+	}
+
+	// stream error
+	var items []*CountItem
+	for item, err := range InvokeStream[SomeReq, CountItem](context.Background(), cli, "MyCount", &SomeReq{"fail"}) {
+		var nf *NotFoundError
+		if err != nil && !errors.As(err, &nf) {
+			t.Fatalf("declared error expected, got %v", err)
+		}
+		if err == nil {
+			items = append(items, item)
+		}
+	}
+	if len(items) != 2 {
+		t.Fatalf("2 items expected before error, got %d", len(items))
+	}
+
+	// invalid calls
+	if _, err := cli.Call("MyCount", &SomeReq{"1"}); err == nil || err.Error() != "method MyCount is streaming, use Stream" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := cli.Stream(context.Background(), "MyMethod", &SomeReq{"1"}); err == nil || err.Error() != "method MyMethod is not streaming" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, err := range InvokeStream[SomeReq, SomeResp](context.Background(), cli, "MyCount", &SomeReq{"1"}) {
+		if err == nil || !strings.HasPrefix(err.Error(), "invalid item type") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// running streams fail on disconnect
+	cs, err = cli.Stream(context.Background(), "MyCount", &SomeReq{"1000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli.Close()
+	for err == nil {
+		_, err = cs.Recv()
+	}
+	if !errors.Is(err, ClosedConnError) {
+		t.Fatalf("connection closed error expected, got %v", err)
+	}
+}
+
+func TestStreamsOutOfPool(t *testing.T) {
+	closech := make(chan struct{})
+	go ServeWSRPC(func() SessionProtocol { return &MyProtocol{} }, ":8095", "/test/wsrpc", &DummyLogger{}, closech,
+		WithWorkers(1, 2), WithQueueSize(1), WithStreamWindow(2))
+	time.Sleep(100 * time.Millisecond)
+	defer close(closech)
+
+	cli, err := ClientWSRPC(&MyProtocol{}, "ws://127.0.0.1:8095/test/wsrpc", time.Second, nil, &DummyLogger{}, WithStreamWindow(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	// stream handlers are blocked until items are received,
+	// requests are dispatched by server in order of receiving
+	for i := 0; i < 5; i++ {
+		cs, err := cli.Stream(context.Background(), "MyCount", &SomeReq{"1000"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cs.Close()
+	}
+	for i := 0; i < 10; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		resp, err := Invoke[SomeReq, SomeResp](ctx, cli, "MyMethod", &SomeReq{"Bob"})
+		cancel()
+		if err != nil || !resp.IsBob {
+			t.Fatalf("unary call failed while streams are open: %v, %v", resp, err)
+		}
+	}
+}
+
+type SProtWithErrStream struct {
+	SProt
+	Notifications struct{}
+}
+
+func (p *SProtWithErrStream) InvalidMethod(r *ReqTest, s BidiStream[*ReqTest, *RespTest]) (*RespTest, error) {
+	return nil, nil
+}
+
+func TestStreamMethodsReflection(t *testing.T) {
+	pd, err := parseSessionProtocol(&MyProtocol{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, exp := range map[string][2]bool{
+		"MyMethod": {false, false},
+		"MyCount":  {true, false},
+		"MyTicks":  {true, false},
+		"MyUpload": {false, true},
+		"MyEcho":   {true, true},
+	} {
+		md := pd.methods[name]
+		if md.streaming != exp[0] || (md.recvType != nil) != exp[1] {
+			t.Fatalf("unexpected stream kind of %s: %v, %v", name, md.streaming, md.recvType)
+		}
+	}
+	if md := pd.methods["MyUpload"]; md.outType.Name() != "CountItem" || md.recvType.Name() != "CountItem" {
+		t.Fatalf("unexpected types of MyUpload: %v, %v", md.outType, md.recvType)
+	}
+
+	_, err = parseSessionProtocol(&SProtWithErrStream{})
+	if err == nil || err.Error() != "stream method must return only error in method InvalidMethod" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestServerRecvStreams(t *testing.T) {
+	ctxErr := make(chan error, 1)
+	conns := make(chan RPCTransport)
+	srv, err := NewRPCServer(conns, func() SessionProtocol { return &MyProtocol{ctxErr: ctxErr} }, &DummyLogger{}, WithStreamWindow(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+
+	conn := NewFakeConn()
+	conns <- conn
+	<-conn.out // hello notification
+
+	expectAck := func(req *Packet, n string) {
+		t.Helper()
+		if p := <-conn.out; p.Header.Type != PT_STREAM_ACK || p.Id() != req.Id() || string(p.Body) != n {
+			t.Fatalf("ack of %s credits expected, got %s", n, p)
+		}
+	}
+
+	// client streaming: window is granted on start, consumed items are acknowledged
+	req := NewPacket(PT_REQUEST, "MyUpload", []byte("{\"name\":\"sum\"}"))
+	conn.in <- req
+	expectAck(req, "2")
+	for i := 1; i <= 4; i++ {
+		conn.in <- req.reply(PT_STREAM_ITEM, []byte("{\"N\":"+strconv.Itoa(i)+"}"))
+		expectAck(req, "1")
+	}
+	conn.in <- req.reply(PT_STREAM_END, []byte("end"))
+	if p := <-conn.out; p.Header.Type != PT_RESPONSE || string(p.Body) != "{\"N\":10}" {
+		t.Fatalf("response expected, got %s", p)
+	}
+
+	// items over window are rejected
+	req = NewPacket(PT_REQUEST, "MyUpload", []byte("{\"name\":\"slow\"}"))
+	conn.in <- req
+	expectAck(req, "2")
+	for i := 0; i < 4; i++ {
+		conn.in <- req.reply(PT_STREAM_ITEM, []byte("{\"N\":1}"))
+	}
+	if p := <-conn.out; p.Header.Type != PT_ERROR || !strings.Contains(string(p.Body), "stream window overflow") {
+		t.Fatalf("overflow error expected, got %s", p)
+	}
+	if err := <-ctxErr; err != context.Canceled {
+		t.Fatalf("cancelled stream expected, got %v", err)
+	}
+
+	// bidirectional: server sends only for credits of client
+	req = NewPacket(PT_REQUEST, "MyEcho", []byte("{\"name\":\"echo\"}"))
+	conn.in <- req
+	expectAck(req, "2")
+	conn.in <- req.reply(PT_STREAM_ITEM, []byte("{\"N\":21}"))
+	expectAck(req, "1")
+	expectNoPacket(t, conn)
+	conn.in <- req.reply(PT_STREAM_ACK, []byte("5"))
+	if p := <-conn.out; p.Header.Type != PT_STREAM_ITEM || string(p.Body) != "{\"N\":42}" {
+		t.Fatalf("echo item expected, got %s", p)
+	}
+	conn.in <- req.reply(PT_STREAM_END, []byte("end"))
+	if p := <-conn.out; p.Header.Type != PT_STREAM_END || p.Id() != req.Id() {
+		t.Fatalf("end of stream expected, got %s", p)
+	}
+}
+
+func TestClientSendStreams(t *testing.T) {
+	ctxErr := make(chan error, 1)
+	closech := make(chan struct{})
+	go ServeWSRPC(func() SessionProtocol { return &MyProtocol{ctxErr: ctxErr} }, ":8091", "/test/wsrpc", &DummyLogger{}, closech)
+	time.Sleep(100 * time.Millisecond)
+	defer close(closech)
+
+	cli, err := ClientWSRPC(&MyProtocol{}, "ws://127.0.0.1:8091/test/wsrpc", time.Second, nil, &DummyLogger{}, WithStreamWindow(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	// client streaming
+	up, err := OpenStream[SomeReq, CountItem, CountItem](context.Background(), cli, "MyUpload", &SomeReq{"sum"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 100; i++ {
+		if err := up.Send(&CountItem{i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := up.CloseAndRecv()
+	if err != nil || resp.N != 5050 {
+		t.Fatalf("sum 5050 expected, got %v, %v", resp, err)
+	}
+
+	// bidirectional
+	echo, err := OpenStream[SomeReq, CountItem, CountItem](context.Background(), cli, "MyEcho", &SomeReq{"echo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 10; i++ {
+		if err := echo.Send(&CountItem{i}); err != nil {
+			t.Fatal(err)
+		}
+		item, err := echo.Recv()
+		if err != nil || item.N != i*2 {
+			t.Fatalf("item %d expected, got %v, %v", i*2, item, err)
+		}
+	}
+	echo.CloseSend()
+	if _, err := echo.Recv(); err != io.EOF {
+		t.Fatalf("end of stream expected, got %v", err)
+	}
+
+	// sender is throttled while echo items are not received
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	echo, err = OpenStream[SomeReq, CountItem, CountItem](ctx, cli, "MyEcho", &SomeReq{"echo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := 0
+	for ; sent < 100; sent++ {
+		if err = echo.Send(&CountItem{sent}); err != nil {
+			break
+		}
+	}
+	if err != context.DeadlineExceeded || sent > 20 {
+		t.Fatalf("blocked sender expected, sent %d items, got %v", sent, err)
+	}
+	if err := <-ctxErr; err != context.Canceled {
+		t.Fatalf("cancelled stream expected, got %v", err)
+	}
+
+	// invalid calls
+	if _, err := OpenStream[SomeReq, CountItem, CountItem](context.Background(), cli, "MyCount", &SomeReq{"1"}); err == nil || err.Error() != "method MyCount doesn't receive stream items" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := OpenStream[SomeReq, CountItem, SomeResp](context.Background(), cli, "MyUpload", &SomeReq{"1"}); err == nil || err.Error() != "invalid response type, *CountItem expected" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, err := range InvokeStream[SomeReq, CountItem](context.Background(), cli, "MyEcho", &SomeReq{"1"}) {
+		if err == nil || err.Error() != "method MyEcho receives stream items, use OpenStream" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package wsrpc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// Invoke calls remote method with typed request and response.
+// Request and response types are checked against client session protocol.
+//
+//	resp, err := wsrpc.Invoke[SumReq, SumResp](ctx, cli, "Sum", &SumReq{12, 44})
+func Invoke[Req, Resp any](ctx context.Context, cli *RPCClient, method string, req *Req) (*Resp, error) {
+	md, ok := cli.protDetails.methods[method]
+	if !ok {
+		return nil, fmt.Errorf("unknown method %s", method)
+	}
+	if t := reflect.TypeOf((*Req)(nil)).Elem(); md.inType != t {
+		return nil, fmt.Errorf("invalid request type, *%s expected", md.inType.Name())
+	}
+	if t := reflect.TypeOf((*Resp)(nil)).Elem(); md.outType != t {
+		return nil, fmt.Errorf("invalid response type, *%s expected", md.outType.Name())
+	}
+
+	resp, err := cli.CallContext(ctx, method, req)
+	if err != nil {
+		return nil, err
+	}
+	ret, ok := resp.(*Resp)
+	if !ok {
+		// response replaced by client interceptor
+		return nil, fmt.Errorf("unexpected response type %T", resp)
+	}
+	return ret, nil
+}
+
+// OnNotification registers typed handler of notification T.
+// T must be declared in Notifications of client session protocol.
+// Handlers are called in notifications loop after client OnNotificationFunc.
+func OnNotification[T any](cli *RPCClient, handler func(*T)) error {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if nt, ok := cli.protDetails.notifications[t.Name()]; !ok || nt != t {
+		return fmt.Errorf("unknown notification %s", t.Name())
+	}
+
+	cli.handlersLock.Lock()
+	defer cli.handlersLock.Unlock()
+	cli.handlers[t.Name()] = append(cli.handlers[t.Name()], func(n interface{}) {
+		tn, ok := n.(*T)
+		if !ok {
+			// notification replaced by client interceptor
+			cli.log.Errorf("unexpected type %T of notification %s", n, t.Name())
+			return
+		}
+		handler(tn)
+	})
+	return nil
+}
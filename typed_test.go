@@ -0,0 +1,128 @@
+package wsrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTypedInvoke(t *testing.T) {
+	conn := NewFakeConn()
+	cli, err := NewRPCClient(conn, &MyProtocol{}, time.Second, nil, &DummyLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	go func() {
+		req := <-conn.out
+		resp := NewPacket(PT_RESPONSE, req.Header.Method, []byte("{\"IsBob\":true}"))
+		resp.Header.MessageId = req.Header.MessageId
+		conn.in <- resp
+
+		req = <-conn.out
+		conn.in <- req.Error(newRemoteError(cli.protDetails, JSONCodec, ErrCodeUnknown, &NotFoundError{"alice"}))
+	}()
+
+	resp, err := Invoke[SomeReq, SomeResp](context.Background(), cli, "MyMethod", &SomeReq{"Bob"})
+	if err != nil || !resp.IsBob {
+		t.Fatalf("unexpected result: %v, %v", resp, err)
+	}
+	_, err = Invoke[SomeReq, SomeResp](context.Background(), cli, "MyFind", &SomeReq{"Alice"})
+	var nf *NotFoundError
+	if !errors.As(err, &nf) {
+		t.Fatalf("declared error expected, got %v", err)
+	}
+
+	_, err = Invoke[SomeReq, SomeResp](context.Background(), cli, "Unknown", &SomeReq{})
+	if err == nil || err.Error() != "unknown method Unknown" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = Invoke[SomeResp, SomeResp](context.Background(), cli, "MyMethod", &SomeResp{})
+	if err == nil || err.Error() != "invalid request type, *SomeReq expected" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = Invoke[SomeReq, SomeReq](context.Background(), cli, "MyMethod", &SomeReq{})
+	if err == nil || err.Error() != "invalid response type, *SomeResp expected" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestTypedNotifications(t *testing.T) {
+	conn := NewFakeConn()
+	untyped := make(chan interface{}, 1)
+	cli, err := NewRPCClient(conn, &MyProtocol{}, time.Second, func(n interface{}, err error) { untyped <- n }, &DummyLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	if err := OnNotification(cli, func(n *SomeResp) {}); err == nil || err.Error() != "unknown notification SomeResp" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	notifs := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		err = OnNotification(cli, func(n *MyNotif) { notifs <- n.Msg })
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn.in <- NewPacket(PT_NOTIFICATION, "MyNotif", []byte("{\"Msg\":\"hello\"}"))
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-notifs:
+			if msg != "hello" {
+				t.Fatalf("unexpected notification %s", msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("typed notification is not delivered")
+		}
+	}
+	if n := (<-untyped).(*MyNotif); n.Msg != "hello" {
+		t.Fatalf("unexpected notification %v", n)
+	}
+}
+
+func TestTypedNotificationsMismatch(t *testing.T) {
+	conn := NewFakeConn()
+	replace := func(ctx context.Context, method string, req interface{}, invoker ClientInvoker) (interface{}, error) {
+		if n, ok := req.(*MyNotif); ok && n.Msg == "replace" {
+			return invoker(ctx, method, n.Msg)
+		}
+		return invoker(ctx, method, req)
+	}
+	errs := make(chan error, 1)
+	cli, err := NewRPCClient(conn, &MyProtocol{}, time.Second, func(n interface{}, err error) {
+		if err != nil {
+			errs <- err
+		}
+	}, &DummyLogger{}, WithClientInterceptors(replace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	notifs := make(chan string, 1)
+	if err := OnNotification(cli, func(n *MyNotif) { notifs <- n.Msg }); err != nil {
+		t.Fatal(err)
+	}
+
+	// neither notification of unexpected type nor unknown notification stops loop
+	conn.in <- NewPacket(PT_NOTIFICATION, "MyNotif", []byte("{\"Msg\":\"replace\"}"))
+	conn.in <- NewPacket(PT_NOTIFICATION, "NoNotif", []byte("{}"))
+	conn.in <- NewPacket(PT_NOTIFICATION, "MyNotif", []byte("{\"Msg\":\"hello\"}"))
+	select {
+	case msg := <-notifs:
+		if msg != "hello" {
+			t.Fatalf("unexpected notification %s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("typed notification is not delivered")
+	}
+	if err := <-errs; err.Error() != "unexpected notification NoNotif" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -5,9 +5,9 @@ import (
 	"time"
 )
 
-func ServeWSRPC(sfunc NewSessionFunc, addr string, path string, log Logger, closeCh chan struct{}) {
-	wsh := NewWsHandler(log)
-	srv, err := NewRPCServer(wsh.Connections(), sfunc, log)
+func ServeWSRPC(sfunc NewSessionFunc, addr string, path string, log Logger, closeCh chan struct{}, opts ...Option) {
+	wsh := NewWsHandler(log, opts...)
+	srv, err := NewRPCServer(wsh.Connections(), sfunc, log, opts...)
 	if err != nil {
 		panic(err)
 	}
@@ -24,11 +24,15 @@ func ServeWSRPC(sfunc NewSessionFunc, addr string, path string, log Logger, clos
 	srv.Close()
 }
 
-func ClientWSRPC(p SessionProtocol, url string, timeout time.Duration, onNotifFunc OnNotificationFunc, log Logger) (*RPCClient, error) {
-	tr, err := NewWsConn(url, log)
+func ClientWSRPC(p SessionProtocol, url string, timeout time.Duration, onNotifFunc OnNotificationFunc, log Logger, opts ...Option) (*RPCClient, error) {
+	tr, err := NewWsConn(url, log, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewRPCClient(tr, p, timeout, onNotifFunc, log)
+	dial := func() (RPCTransport, error) {
+		return NewWsConn(url, log, opts...)
+	}
+	opts = append(opts, withDefaultDial(dial))
+	return NewRPCClient(tr, p, timeout, onNotifFunc, log, opts...)
 }
@@ -1,74 +1,298 @@
 package wsrpc
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"reflect"
+	"runtime/debug"
 	"sync"
+	"time"
 )
 
 type job struct {
+	ctx    context.Context
 	prot   SessionProtocol
+	conn   *RPCConn
 	packet *Packet
-	respCh chan *Packet
+	stream bool   // streaming call, it is served out of pool
+	done   func() // called after job is processed
 }
 
 type workersPool struct {
+	sync.Mutex
 	jobs        chan job
 	wg          sync.WaitGroup
 	log         Logger
 	protDetails *protocolDetails
+	onPanic     PanicHandler
+	interceptor ServerInterceptor
+	authorize   Authorizer
+
+	minWorkers  int
+	maxWorkers  int
+	idleTimeout time.Duration
+	workers     int // number of running workers
+	idle        int // number of workers waiting for job
+	maxStreams  int
+	streams     int // number of running streaming calls
+	closed      bool
+}
+
+func newWorkersPool(o *options, pd *protocolDetails, log Logger) *workersPool {
+	wp := &workersPool{
+		jobs:        make(chan job, o.queueSize),
+		log:         log,
+		protDetails: pd,
+		onPanic:     o.panicHandler,
+		interceptor: chainServerInterceptors(o.serverInterceptors),
+		authorize:   newAuthorizer(o.methodRoles, o.authorizer),
+		minWorkers:  o.minWorkers,
+		maxWorkers:  o.maxWorkers,
+		idleTimeout: o.workerIdleTimeout,
+		maxStreams:  o.maxStreams,
+	}
+	wp.Lock()
+	for i := 0; i < wp.minWorkers; i++ {
+		wp.spawn()
+	}
+	wp.Unlock()
+	return wp
+}
+
+// spawn starts new worker, must be called with locked pool
+func (wp *workersPool) spawn() {
+	wp.workers++
+	wp.idle++
+	wp.wg.Add(1)
+	go wp.worker()
 }
 
 func (wp *workersPool) worker() {
 	wp.log.Debug("starting new worker")
 	defer wp.wg.Done()
 
-	for j := range wp.jobs {
-		j.respCh <- wp.callMethod(j.prot, j.packet)
+	idleTimer := time.NewTimer(wp.idleTimeout)
+	defer idleTimer.Stop()
+	for {
+		select {
+		case j, ok := <-wp.jobs:
+			if !ok {
+				wp.log.Debug("worker stopped")
+				return
+			}
+			wp.Lock()
+			wp.idle--
+			wp.Unlock()
+
+			wp.serve(j)
+
+			wp.Lock()
+			wp.idle++
+			wp.Unlock()
+
+		case <-idleTimer.C:
+			wp.Lock()
+			if wp.workers > wp.minWorkers {
+				// reap idle worker
+				wp.workers--
+				wp.idle--
+				wp.Unlock()
+				wp.log.Debug("idle worker stopped")
+				return
+			}
+			wp.Unlock()
+		}
+
+		if !idleTimer.Stop() {
+			select {
+			case <-idleTimer.C:
+			default:
+			}
+		}
+		idleTimer.Reset(wp.idleTimeout)
 	}
-	wp.log.Debug("worker stopped")
 }
 
-func (wp *workersPool) Process(j job) {
+// serve processes job in current goroutine
+func (wp *workersPool) serve(j job) {
+	if j.packet.Header.Type == PT_NOTIFICATION {
+		wp.callNotification(j.prot, j.conn, j.packet)
+	} else {
+		resp := wp.callMethod(j.ctx, j.prot, j.conn, j.packet)
+		if j.conn.finishCall(j.packet.Id()) {
+			j.conn.send(resp)
+		}
+	}
+	if j.done != nil {
+		j.done()
+	}
+}
+
+// Process queues job for processing, false is returned if pool is overloaded
+func (wp *workersPool) Process(j job) bool {
+	wp.Lock()
+	defer wp.Unlock()
+	if wp.closed {
+		return false
+	}
+	if wp.idle <= len(wp.jobs) && wp.workers < wp.maxWorkers {
+		wp.spawn()
+	}
+
 	select {
 	case wp.jobs <- j:
+		return true
 	default:
-		wp.wg.Add(1)
-		go wp.worker()
-		wp.jobs <- j
+		return false
 	}
 }
 
+// ProcessStream serves streaming job in new goroutine, so long living stream
+// doesn't hold pool worker. False is returned if limit of streams is reached
+func (wp *workersPool) ProcessStream(j job) bool {
+	wp.Lock()
+	defer wp.Unlock()
+	if wp.closed || wp.streams >= wp.maxStreams {
+		return false
+	}
+	wp.streams++
+
+	go func() {
+		wp.serve(j)
+
+		wp.Lock()
+		wp.streams--
+		wp.Unlock()
+	}()
+	return true
+}
+
 func (wp *workersPool) Close() {
+	wp.Lock()
+	wp.closed = true
 	close(wp.jobs)
+	wp.Unlock()
 	wp.wg.Wait()
 }
 
-func (wp *workersPool) callMethod(p SessionProtocol, packet *Packet) *Packet {
-	m, ok := wp.protDetails.methods[packet.Header.Method] //FIXME lock (?)
+// callNotification passes client notification to its handler
+func (wp *workersPool) callNotification(p SessionProtocol, conn *RPCConn, packet *Packet) {
+	name := packet.Header.Method
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			wp.log.Errorf("panic in client notification %s handler: %v\n%s", name, r, stack)
+			if wp.onPanic != nil {
+				wp.onPanic(conn, "On"+name, r, stack)
+			}
+		}
+	}()
+
+	nd, ok := wp.protDetails.clientNotifications[name]
 	if !ok {
-		return packet.Error(
-			fmt.Errorf("no method %s found", packet.Header.Method),
-		)
+		wp.log.Errorf("unexpected client notification %s", name)
+		return
+	}
+	if wp.authorize != nil {
+		if err := wp.authorize(conn, name); err != nil {
+			wp.log.Warningf("client notification %s dropped: %s", name, err.Error())
+			return
+		}
+	}
+	if err := conn.checkPhase(name); err != nil {
+		wp.log.Warningf("client notification %s dropped: %s", name, err.Error())
+		return
 	}
+	nV := reflect.New(nd.nType)
+	if err := conn.codec.Unmarshal(packet.Body, nV.Interface()); err != nil {
+		wp.log.Errorf("can't decode client notification %s: %s", name, err.Error())
+		return
+	}
+	nd.call(p, nV.Interface())
+}
 
-	inV := reflect.New(m.inType)
-	err := json.Unmarshal(packet.Body, inV.Interface())
-	if err != nil {
+func (wp *workersPool) callMethod(ctx context.Context, p SessionProtocol, conn *RPCConn, packet *Packet) (resp *Packet) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			wp.log.Errorf("panic in method %s: %v\n%s", packet.Header.Method, r, stack)
+			if wp.onPanic != nil {
+				wp.onPanic(conn, packet.Header.Method, r, stack)
+			}
+			resp = packet.Error(ErrInternal)
+		}
+	}()
+
+	if _, ok := wp.protDetails.methods[packet.Header.Method]; ok && wp.authorize != nil {
+		// call is rejected before request is decoded
+		if err := wp.authorize(conn, packet.Header.Method); err != nil {
+			return packet.Error(&RemoteError{Code: ErrCodePermissionDenied, Message: err.Error()})
+		}
+	}
+	if err := conn.checkPhase(packet.Header.Method); err != nil {
 		return packet.Error(err)
 	}
 
-	ret := m.funcVal.Call([]reflect.Value{reflect.ValueOf(p), inV})
+	return dispatchRequest(ctx, wp.protDetails, conn.codec, packet, func(ctx context.Context, m methodDetails, req interface{}) (interface{}, error) {
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			if m.isStream() {
+				st := conn.stream(packet.Id())
+				if st == nil {
+					// stream is cancelled by client
+					return nil, context.Canceled
+				}
+				return m.callStream(ctx, p, req, st)
+			}
+			return m.call(ctx, p, req)
+		}
+		if wp.interceptor != nil {
+			return wp.interceptor(ctx, conn, packet.Header.Method, req, handler)
+		}
+		return handler(ctx, req)
+	})
+}
+
+// dispatchRequest decodes request packet, calls method with invoke and
+// returns response or error packet. It is used by server and client
+// (for requests of server) sides.
+func dispatchRequest(
+	ctx context.Context,
+	pd *protocolDetails,
+	codec Codec,
+	packet *Packet,
+	invoke func(ctx context.Context, m methodDetails, req interface{}) (interface{}, error),
+) *Packet {
+	m, ok := pd.methods[packet.Header.Method]
+	if !ok {
+		return packet.Error(&RemoteError{
+			Code:    ErrCodeMethodNotFound,
+			Message: fmt.Sprintf("no method %s found", packet.Header.Method),
+		})
+	}
+
+	if ctx.Err() != nil {
+		// request is cancelled before processing
+		return packet.Error(ctx.Err())
+	}
 
-	var buf []byte
-	if !ret[1].IsNil() { // check error
-		return packet.Error(ret[1].Interface().(error))
+	inV := reflect.New(m.inType)
+	err := codec.Unmarshal(packet.Body, inV.Interface())
+	if err != nil {
+		return packet.Error(&RemoteError{Code: ErrCodeInvalidRequest, Message: err.Error()})
 	}
 
-	buf, err = json.Marshal(ret[0].Interface())
+	out, err := invoke(ctx, m, inV.Interface())
 	if err != nil {
-		return packet.Error(err)
+		return packet.Error(newRemoteError(pd, codec, ErrCodeUnknown, err))
+	}
+	if m.streaming {
+		// packet body can't be empty
+		return packet.reply(PT_STREAM_END, []byte("end"))
+	}
+
+	buf, err := codec.Marshal(out)
+	if err != nil {
+		return packet.Error(&RemoteError{Code: ErrCodeInternal, Message: err.Error()})
 	}
 
 	h := Header{
@@ -0,0 +1,242 @@
+package wsrpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestConn() *RPCConn {
+	pd, _ := parseSessionProtocol(&MyProtocol{})
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := &RPCConn{
+		protDetails: pd,
+		codec:       JSONCodec,
+		out:         newOutboundQueue(16, BlockSender),
+		ctx:         ctx,
+		cancel:      cancel,
+		calls:       make(map[string]context.CancelFunc),
+	}
+	go func() {
+		for {
+			if conn.out.pop() != nil {
+				continue
+			}
+			select {
+			case <-conn.out.ready:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return conn
+}
+
+func (wp *workersPool) stats() (int, int) {
+	wp.Lock()
+	defer wp.Unlock()
+	return wp.workers, wp.idle
+}
+
+func TestWorkersPoolReaping(t *testing.T) {
+	conn := newTestConn()
+	defer conn.cancel()
+
+	wp := newWorkersPool(
+		newOptions([]Option{WithWorkers(1, 4), WithQueueSize(2), WithWorkerIdleTimeout(50 * time.Millisecond)}),
+		conn.protDetails, &DummyLogger{},
+	)
+	if w, _ := wp.stats(); w != 1 {
+		t.Fatalf("1 worker expected on start, got %d", w)
+	}
+
+	ids := []string{}
+	for i := 0; i < 6; i++ {
+		p := NewPacket(PT_REQUEST, "MyWait", []byte("{}"))
+		ids = append(ids, p.Id())
+		if !wp.Process(job{ctx: conn.startCall(p.Id()), prot: &MyProtocol{}, conn: conn, packet: p}) {
+			t.Fatalf("job #%d rejected", i)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if w, idle := wp.stats(); w != 4 || idle != 0 {
+		t.Fatalf("4 busy workers expected, got %d (%d idle)", w, idle)
+	}
+
+	// queue is full now
+	p := NewPacket(PT_REQUEST, "MyWait", []byte("{}"))
+	if wp.Process(job{ctx: conn.startCall(p.Id()), prot: &MyProtocol{}, conn: conn, packet: p}) {
+		t.Fatal("job must be rejected by overloaded pool")
+	}
+	conn.finishCall(p.Id())
+
+	for _, id := range ids {
+		conn.cancelCall(id)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if w, idle := wp.stats(); w != 1 || idle != 1 {
+		t.Fatalf("1 idle worker expected after reaping, got %d (%d idle)", w, idle)
+	}
+
+	wp.Close()
+	if wp.Process(job{ctx: conn.ctx, prot: &MyProtocol{}, conn: conn, packet: p}) {
+		t.Fatal("closed pool must reject jobs")
+	}
+}
+
+func TestServerOverload(t *testing.T) {
+	conns := make(chan RPCTransport)
+	srv, err := NewRPCServer(
+		conns, func() SessionProtocol { return &MyProtocol{} }, &DummyLogger{LL_ERROR},
+		WithWorkers(1, 1), WithQueueSize(1),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+
+	conn := NewFakeConn()
+	conns <- conn
+	<-conn.out // hello notification
+
+	running := NewPacket(PT_REQUEST, "MyWait", []byte("{}"))
+	conn.in <- running
+	time.Sleep(50 * time.Millisecond)
+	queued := NewPacket(PT_REQUEST, "MyWait", []byte("{}"))
+	conn.in <- queued
+	time.Sleep(50 * time.Millisecond)
+
+	rejected := NewPacket(PT_REQUEST, "MyMethod", []byte("{\"name\":\"Bob\"}"))
+	conn.in <- rejected
+	p := <-conn.out
+	if p.Header.Type != PT_ERROR || p.Id() != rejected.Id() {
+		t.Fatalf("overloaded error expected, got %s", p)
+	}
+	re := parseRemoteError(srv.protDetails, JSONCodec, p.Body)
+	if re.Code != ErrCodeOverloaded || re.Message != "server overloaded" {
+		t.Fatalf("unexpected error %+v", re)
+	}
+
+	// server recovers after load is gone
+	conn.in <- running.Cancel(context.Canceled)
+	conn.in <- queued.Cancel(context.Canceled)
+	time.Sleep(50 * time.Millisecond)
+	req := conn.simulateReq()
+	p = <-conn.out
+	if p.Header.Type != PT_RESPONSE || p.Id() != req.Id() {
+		t.Fatalf("response expected, got %s", p)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	type panicInfo struct {
+		conn      *RPCConn
+		method    string
+		recovered interface{}
+		stack     []byte
+	}
+	panics := make(chan panicInfo, 1)
+	onPanic := func(conn *RPCConn, method string, recovered interface{}, stack []byte) {
+		panics <- panicInfo{conn, method, recovered, stack}
+	}
+
+	conns := make(chan RPCTransport)
+	srv, err := NewRPCServer(conns, func() SessionProtocol { return &MyProtocol{} }, &DummyLogger{}, WithPanicHandler(onPanic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+
+	conn := NewFakeConn()
+	conns <- conn
+	<-conn.out // hello notification
+
+	req := NewPacket(PT_REQUEST, "MyPanic", []byte("{\"name\":\"Bob\"}"))
+	conn.in <- req
+	p := <-conn.out
+	if p.Header.Type != PT_ERROR || p.Id() != req.Id() {
+		t.Fatalf("error response expected, got %s", p)
+	}
+	re := parseRemoteError(srv.protDetails, JSONCodec, p.Body)
+	if re.Code != ErrCodeInternal || re.Message != "internal error" {
+		t.Fatalf("unexpected error %+v", re)
+	}
+
+	pi := <-panics
+	if pi.conn == nil || pi.method != "MyPanic" {
+		t.Fatalf("unexpected panic info %+v", pi)
+	}
+	if e, ok := pi.recovered.(error); !ok || e.Error() != "assignment to entry in nil map" {
+		t.Fatalf("unexpected recovered value %v", pi.recovered)
+	}
+	if !strings.Contains(string(pi.stack), "MyPanic") {
+		t.Fatalf("stack trace expected, got %s", pi.stack)
+	}
+
+	// server is still alive
+	req = conn.simulateReq()
+	p = <-conn.out
+	if p.Header.Type != PT_RESPONSE || p.Id() != req.Id() {
+		t.Fatalf("response expected, got %s", p)
+	}
+}
+
+func TestStreamsLimit(t *testing.T) {
+	conns := make(chan RPCTransport)
+	srv, err := NewRPCServer(
+		conns, func() SessionProtocol { return &MyProtocol{} }, &DummyLogger{LL_ERROR},
+		WithMaxStreams(2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+
+	conn := NewFakeConn()
+	conns <- conn
+	<-conn.out // hello notification
+
+	// stream handlers are blocked waiting for credits
+	var running []*Packet
+	for i := 0; i < 2; i++ {
+		st := NewPacket(PT_REQUEST, "MyCount", []byte("{\"name\":\"1000\"}"))
+		conn.in <- st
+		running = append(running, st)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	rejected := NewPacket(PT_REQUEST, "MyCount", []byte("{\"name\":\"1\"}"))
+	conn.in <- rejected
+	p := <-conn.out
+	if p.Header.Type != PT_ERROR || p.Id() != rejected.Id() {
+		t.Fatalf("overloaded error expected, got %s", p)
+	}
+	re := parseRemoteError(srv.protDetails, JSONCodec, p.Body)
+	if re.Code != ErrCodeOverloaded {
+		t.Fatalf("unexpected error %+v", re)
+	}
+
+	// unary calls are not limited by streams
+	req := NewPacket(PT_REQUEST, "MyMethod", []byte("{\"name\":\"Bob\"}"))
+	conn.in <- req
+	if p := <-conn.out; p.Header.Type != PT_RESPONSE || p.Id() != req.Id() {
+		t.Fatalf("response expected, got %s", p)
+	}
+
+	// finished stream frees its slot
+	conn.in <- running[0].Cancel(context.Canceled)
+	time.Sleep(50 * time.Millisecond)
+	st := NewPacket(PT_REQUEST, "MyCount", []byte("{\"name\":\"1\"}"))
+	conn.in <- st
+	conn.in <- st.reply(PT_STREAM_ACK, []byte("10"))
+	for _, tp := range []uint8{PT_STREAM_ITEM, PT_STREAM_END} {
+		if p := <-conn.out; p.Header.Type != tp || p.Id() != st.Id() {
+			t.Fatalf("packet type %s expected, got %s", printableType(tp), p)
+		}
+	}
+}
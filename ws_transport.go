@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
+	"net/netip"
 	"sync"
 	"time"
 
@@ -25,6 +26,8 @@ type WsTransport struct {
 	pingTicker *time.Ticker
 	pongWait   time.Duration
 	writeWait  time.Duration
+	identity   interface{} // set by authenticator of handshake
+	info       ConnInfo
 
 	log Logger
 }
@@ -60,6 +63,21 @@ func NewWsTransport(c *websocket.Conn, needPing bool, log Logger) *WsTransport {
 	return t
 }
 
+// CodecName returns body codec negotiated in websocket subprotocol handshake
+func (t *WsTransport) CodecName() string {
+	return codecNameFromSubprotocol(t.conn.Subprotocol())
+}
+
+// ConnInfo returns details of connection accepted by WsHandler
+func (t *WsTransport) ConnInfo() ConnInfo {
+	return t.info
+}
+
+// Identity returns identity of client returned by authenticator of handshake
+func (t *WsTransport) Identity() interface{} {
+	return t.identity
+}
+
 func (t *WsTransport) Recv() (*Packet, error) {
 	_, raw, err := t.conn.ReadMessage()
 	if err != nil {
@@ -122,19 +140,25 @@ func (t *WsTransport) pingLoop() {
 }
 
 type WsHandler struct {
-	conns    chan RPCTransport
-	upgrader websocket.Upgrader
-	log      Logger
+	conns          chan RPCTransport
+	upgrader       websocket.Upgrader
+	auth           Authenticator
+	trustedProxies []netip.Prefix
+	log            Logger
 }
 
-func NewWsHandler(log Logger) *WsHandler {
+func NewWsHandler(log Logger, opts ...Option) *WsHandler {
+	o := newOptions(opts)
 	return &WsHandler{
 		conns: make(chan RPCTransport),
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
+			Subprotocols:    codecSubprotocols(o.codecs),
 		},
-		log: log,
+		auth:           o.authenticator,
+		trustedProxies: o.trustedProxies,
+		log:            log,
 	}
 }
 
@@ -143,6 +167,10 @@ func (h *WsHandler) Connections() <-chan RPCTransport {
 }
 
 func (h *WsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	identity, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
 	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		h.log.Errorf("websocket upgrade fails: %s", err.Error())
@@ -150,12 +178,23 @@ func (h *WsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "websocket upgrade fails: %s", err.Error())
 		return
 	}
-	h.conns <- NewWsTransport(conn, true, h.log)
+	t := NewWsTransport(conn, true, h.log)
+	t.identity = identity
+	t.info = newConnInfo(r, conn.Subprotocol(), h.trustedProxies)
+	h.conns <- t
 }
 
-func NewWsConn(url string, log Logger) (*WsTransport, error) {
-	dialer := &websocket.Dialer{HandshakeTimeout: 60 * time.Second}
-	conn, resp, err := dialer.Dial(url, http.Header{})
+func NewWsConn(url string, log Logger, opts ...Option) (*WsTransport, error) {
+	o := newOptions(opts)
+	dialer := &websocket.Dialer{
+		HandshakeTimeout: 60 * time.Second,
+		Subprotocols:     codecSubprotocols(o.codecs),
+	}
+	header := o.handshakeHeader
+	if header == nil {
+		header = http.Header{}
+	}
+	conn, resp, err := dialer.Dial(url, header)
 	if err != nil {
 		log.Debugf("response: %s", resp)
 		return nil, err